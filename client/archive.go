@@ -0,0 +1,183 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+	ArchiveTarXz  = "tar.xz"
+	ArchiveZip    = "zip"
+)
+
+// The "tgz" name predates support for other formats and is kept as an alias
+var archiveFormats = map[string]string{
+	"tgz":         ArchiveTarGz,
+	ArchiveTarGz:  ArchiveTarGz,
+	ArchiveTarZst: ArchiveTarZst,
+	ArchiveTarXz:  ArchiveTarXz,
+	ArchiveZip:    ArchiveZip,
+}
+
+var archiveMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{ArchiveTarGz, []byte{0x1f, 0x8b}},
+	{ArchiveTarZst, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{ArchiveTarXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{ArchiveZip, []byte{'P', 'K', 0x03, 0x04}},
+	{ArchiveZip, []byte{'P', 'K', 0x05, 0x06}},
+}
+
+// archiveReader walks the entries of an archive. It is satisfied by
+// tar.Reader, and zip files are adapted to it so that extraction and
+// compose file discovery only have to deal with a single interface.
+type archiveReader interface {
+	Next() (*tar.Header, error)
+	Read(b []byte) (int, error)
+}
+
+// Returns the canonical name of an archiveFormat value from custom data
+func normalizeArchiveFormat(format string) (string, error) {
+	if len(format) == 0 {
+		return "", nil
+	}
+	canonical, ok := archiveFormats[format]
+	if !ok {
+		return "", fmt.Errorf("Unsupported archive format: %s", format)
+	}
+	return canonical, nil
+}
+
+func detectArchiveFormat(buf []byte) (string, error) {
+	for _, m := range archiveMagic {
+		if bytes.HasPrefix(buf, m.magic) {
+			return m.format, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to detect archive format")
+}
+
+// newArchiveReader opens buf as the given format. If format is empty, it
+// will be detected from the content's magic bytes.
+func newArchiveReader(buf []byte, format string) (archiveReader, error) {
+	format, err := normalizeArchiveFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	if len(format) == 0 {
+		if format, err = detectArchiveFormat(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var stream io.Reader
+	switch format {
+	case ArchiveTarGz:
+		stream, err = gzip.NewReader(bytes.NewReader(buf))
+	case ArchiveTarZst:
+		stream, err = zstdDecode(buf)
+	case ArchiveTarXz:
+		stream, err = xz.NewReader(bytes.NewReader(buf))
+	case ArchiveZip:
+		zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open %s archive: %s", format, err)
+		}
+		return &zipReader{files: zr.File}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decompress %s archive: %s", format, err)
+	}
+	return tar.NewReader(stream), nil
+}
+
+// The streaming zstd decoder runs background goroutines until closed, so
+// decode in one shot rather than tying its lifetime to the tar reader.
+func zstdDecode(buf []byte) (io.Reader, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	out, err := dec.DecodeAll(buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out), nil
+}
+
+func openArchive(fileName, format string) (archiveReader, error) {
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveReader(buf, format)
+}
+
+// zipReader presents the entries of a zip file as tar headers
+type zipReader struct {
+	files []*zip.File
+	idx   int
+	cur   io.ReadCloser
+}
+
+func (z *zipReader) Next() (*tar.Header, error) {
+	if z.cur != nil {
+		z.cur.Close()
+		z.cur = nil
+	}
+	if z.idx >= len(z.files) {
+		return nil, io.EOF
+	}
+	f := z.files[z.idx]
+	z.idx++
+
+	header := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(f.Mode().Perm()),
+		ModTime: f.Modified,
+	}
+	if f.Mode().IsDir() {
+		header.Typeflag = tar.TypeDir
+		return header, nil
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s from zip: %s", f.Name, err)
+	}
+	if f.Mode()&os.ModeSymlink != 0 {
+		defer rc.Close()
+		target, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read symlink %s from zip: %s", f.Name, err)
+		}
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(target)
+		return header, nil
+	}
+	header.Typeflag = tar.TypeReg
+	header.Size = int64(f.UncompressedSize64)
+	z.cur = rc
+	return header, nil
+}
+
+func (z *zipReader) Read(b []byte) (int, error) {
+	if z.cur == nil {
+		return 0, io.EOF
+	}
+	return z.cur.Read(b)
+}
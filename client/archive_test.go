@@ -0,0 +1,154 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func createArchive(t *testing.T, format string, contents map[string]string) []byte {
+	var buf bytes.Buffer
+	if format == ArchiveZip {
+		zw := zip.NewWriter(&buf)
+		for name, content := range contents {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	var w io.WriteCloser
+	var err error
+	switch format {
+	case ArchiveTarGz:
+		w = gzip.NewWriter(&buf)
+	case ArchiveTarZst:
+		w, err = zstd.NewWriter(&buf)
+	case ArchiveTarXz:
+		w, err = xz.NewWriter(&buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(w)
+	for name, content := range contents {
+		hdr := &tar.Header{
+			Name: name,
+			Mode: 0600,
+			Size: int64(len(content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveFormats(t *testing.T) {
+	contents := map[string]string{"foo/docker-compose.yml": "{}"}
+	formats := []string{ArchiveTarGz, ArchiveTarZst, ArchiveTarXz, ArchiveZip}
+	for _, format := range formats {
+		buf := createArchive(t, format, contents)
+
+		detected, err := detectArchiveFormat(buf)
+		if err != nil {
+			t.Errorf("Unable to detect %s: %s", format, err)
+		} else if detected != format {
+			t.Errorf("Detected format %s != %s", detected, format)
+		}
+
+		// Once by magic bytes and once by the declared format
+		for _, declared := range []string{"", format} {
+			tr, err := newArchiveReader(buf, declared)
+			if err != nil {
+				t.Fatalf("Unable to open %s: %s", format, err)
+			}
+			if _, err := composeFiles(true, nil, tr); err != nil {
+				t.Errorf("composeFiles failed for %s: %s", format, err)
+			}
+		}
+	}
+}
+
+func TestArchiveFormatInvalid(t *testing.T) {
+	if _, err := detectArchiveFormat([]byte("not an archive")); err == nil {
+		t.Error("Archive detection should have failed")
+	}
+	if _, err := newArchiveReader([]byte{}, "rar"); err == nil {
+		t.Error("Unsupported archive format should have failed")
+	}
+	if format, err := normalizeArchiveFormat("tgz"); err != nil || format != ArchiveTarGz {
+		t.Errorf("tgz should be an alias for %s: %s %s", ArchiveTarGz, format, err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf := createArchive(t, ArchiveZip, map[string]string{"foo/bar": "bam"})
+	archive := path.Join(dir, "archive")
+	if err := ioutil.WriteFile(archive, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dst := path.Join(dir, "dst")
+	if err := os.Mkdir(dst, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractFile(archive, dst, "", true); err != nil {
+		t.Fatalf("Unable to extract zip: %s", err)
+	}
+	content, err := ioutil.ReadFile(path.Join(dst, "bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "bam" {
+		t.Errorf("Invalid extracted content: %s != bam", content)
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"../escape", "foo/../../escape"} {
+		buf := createArchive(t, ArchiveTarXz, map[string]string{name: "bad"})
+		tr, err := newArchiveReader(buf, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := extract(tr, dir, false); err == nil {
+			t.Errorf("Extracting %s should have failed", name)
+		} else {
+			t.Logf("Error message: %s", err)
+		}
+	}
+}
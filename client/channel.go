@@ -0,0 +1,132 @@
+package client
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+var channelRe = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+// Returns the fields common to OSTREE and DOCKER_COMPOSE custom data
+func tufCustom(target *client.TargetWithRole) (*TUFCustom, error) {
+	custom := TUFCustom{}
+	if target.Custom != nil {
+		if err := json.Unmarshal(*target.Custom, &custom); err != nil {
+			return nil, err
+		}
+	}
+	return &custom, nil
+}
+
+// Reports whether a target is tagged for the device's channel. Every
+// target matches when the device isn't subscribed to one.
+func (d *Device) onChannel(custom *TUFCustom) bool {
+	if len(d.Config.Channel) == 0 {
+		return true
+	}
+	for _, tag := range custom.Tags {
+		if tag == d.Config.Channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns why the device may not update to a target, empty when it may
+func (d *Device) unavailableReason(target *client.TargetWithRole, revoked *Revocations) string {
+	if revoked.Revoked(target) {
+		return "it has been revoked"
+	}
+	custom, err := tufCustom(target)
+	if err != nil {
+		return fmt.Sprintf("unable to parse its custom data: %s", err)
+	}
+	if !d.onChannel(custom) {
+		return fmt.Sprintf("it is not tagged for channel %s", d.Config.Channel)
+	}
+	if !custom.Rollout.Includes(d.Config.DeviceId) {
+		return "its rollout does not include this device"
+	}
+	return ""
+}
+
+// AvailableTargets returns the targets the device may update to: those
+// tagged for its channel whose rollout includes it and that aren't revoked
+func (d *Device) AvailableTargets(targets []*client.TargetWithRole) []*client.TargetWithRole {
+	revoked := NewRevocations(targets)
+	var available []*client.TargetWithRole
+	for _, target := range targets {
+		if target.Name == BlocklistTarget {
+			continue
+		}
+		if reason := d.unavailableReason(target, revoked); len(reason) > 0 {
+			logrus.Debugf("Skipping %s: %s", target.Name, reason)
+			continue
+		}
+		available = append(available, target)
+	}
+	return available
+}
+
+// Returns the first available target that matches. nil is returned when
+// none match and an UnavailableTargetError when only unavailable ones do.
+func (d *Device) findTarget(targets []*client.TargetWithRole, matches func(*client.TargetWithRole) bool) (*client.TargetWithRole, error) {
+	revoked := NewRevocations(targets)
+	var unavailable error
+	for _, target := range targets {
+		if target.Name == BlocklistTarget || !matches(target) {
+			continue
+		}
+		reason := d.unavailableReason(target, revoked)
+		if len(reason) == 0 {
+			return target, nil
+		}
+		if unavailable == nil {
+			unavailable = &UnavailableTargetError{target.Name, reason}
+		}
+	}
+	return nil, unavailable
+}
+
+// FindBaseTarget returns the available base target of a version, or the
+// latest one for "latest". Requesting a version the device may not update
+// to fails with an UnavailableTargetError.
+func (d *Device) FindBaseTarget(targets []*client.TargetWithRole, version string) (*client.TargetWithRole, error) {
+	return d.findTarget(targets, func(target *client.TargetWithRole) bool {
+		ver, _, err := BaseVersionSplit(target.Name)
+		if err != nil {
+			logrus.Debug(err)
+			return false
+		}
+		return version == "latest" || ver == version
+	})
+}
+
+// FindPersonalityTarget returns the available personality target of a
+// name, or the latest one for "latest". Requesting one the device may not
+// update to fails with an UnavailableTargetError.
+func (d *Device) FindPersonalityTarget(targets []*client.TargetWithRole, name string) (*client.TargetWithRole, error) {
+	return d.findTarget(targets, func(target *client.TargetWithRole) bool {
+		return name == "latest" || target.Name == name
+	})
+}
+
+// Subscribes the device to a channel and saves the configuration. An empty
+// channel makes every target available.
+func (d *Device) SetChannel(channel string) error {
+	if len(channel) > 0 && !channelRe.MatchString(channel) {
+		return fmt.Errorf("Invalid channel name '%s'", channel)
+	}
+	newConfig := d.Config
+	newConfig.Channel = channel
+	if err := saveConfig(path.Join(d.configDir, "config.json"), newConfig); err != nil {
+		return err
+	}
+	d.Config = newConfig
+	return nil
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/theupdateframework/notary/client"
+)
+
+func targetNames(targets []*client.TargetWithRole) string {
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestAvailableTargets(t *testing.T) {
+	targets := []*client.TargetWithRole{
+		newTestTarget("v4-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["lab-42"]}`),
+		newTestTarget("v3-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["beta"]}`),
+		newTestTarget("v2-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["stable", "beta"]}`),
+		newTestTarget("v1-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x"}`),
+	}
+	d := Device{}
+	if names := targetNames(d.AvailableTargets(targets)); names != "v4-intel,v3-intel,v2-intel,v1-intel" {
+		t.Errorf("Every target should be available without a channel: %s", names)
+	}
+	d.Config.Channel = "beta"
+	if names := targetNames(d.AvailableTargets(targets)); names != "v3-intel,v2-intel" {
+		t.Errorf("Unexpected beta targets: %s", names)
+	}
+	d.Config.Channel = "stable"
+	if names := targetNames(d.AvailableTargets(targets)); names != "v2-intel" {
+		t.Errorf("Unexpected stable targets: %s", names)
+	}
+}
+
+func TestFindTarget(t *testing.T) {
+	targets := []*client.TargetWithRole{
+		newTestTarget("v4-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["beta"], "rollout": {"percentage": 0}}`),
+		newTestTarget("v3-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["beta"], "revoked": true}`),
+		newTestTarget("v2-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["beta"]}`),
+		newTestTarget("v1-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "tags": ["stable"]}`),
+	}
+	d := Device{Config: DeviceConfig{Channel: "beta", DeviceId: "abc123"}}
+
+	if target, err := d.FindBaseTarget(targets, "latest"); err != nil || target.Name != "v2-intel" {
+		t.Errorf("Latest should skip unavailable targets: %v %v", target, err)
+	}
+	if target, err := d.FindBaseTarget(targets, "v2"); err != nil || target.Name != "v2-intel" {
+		t.Errorf("Unexpected v2 target: %v %v", target, err)
+	}
+	for _, ver := range []string{"v1", "v3", "v4"} {
+		_, err := d.FindBaseTarget(targets, ver)
+		if _, ok := err.(*UnavailableTargetError); !ok {
+			t.Errorf("%s should be unavailable: %v", ver, err)
+		}
+	}
+	if target, err := d.FindBaseTarget(targets, "v5"); target != nil || err != nil {
+		t.Errorf("Missing version should not be found: %v %v", target, err)
+	}
+	if target, err := d.FindPersonalityTarget(targets, "v1-intel"); target != nil || err == nil {
+		t.Errorf("Off channel personality should be unavailable: %v %v", target, err)
+	}
+}
+
+func TestSetChannel(t *testing.T) {
+	dir := t.TempDir()
+	d := Device{configDir: dir}
+	if err := d.SetChannel("lab 42"); err == nil {
+		t.Error("Invalid channel names should fail")
+	}
+	if err := d.SetChannel("lab-42"); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Config.Channel != "lab-42" || !strings.Contains(string(buf), `"Channel":"lab-42"`) {
+		t.Errorf("Channel not saved: %s", buf)
+	}
+}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// By default the current archive and 2 previous ones are kept for rollbacks
+const DefaultKeepArchives = 3
+
+type CleanupOptions struct {
+	KeepArchives          int
+	OSTreeKeepYoungerThan string
+	SkipOSTree            bool
+	SkipDocker            bool
+}
+
+// Returns the cleanup options configured for the device
+func (d *Device) CleanupOptions() CleanupOptions {
+	opts := CleanupOptions{
+		KeepArchives:          d.Config.Retention.KeepArchives,
+		OSTreeKeepYoungerThan: d.Config.Retention.OSTreeKeepYoungerThan,
+	}
+	if opts.KeepArchives <= 0 {
+		opts.KeepArchives = DefaultKeepArchives
+	}
+	return opts
+}
+
+// Returns the hashes of the archives each personality should keep: the one
+// staged, the one installed and the most recent previous installs from the
+// history
+func (d *Device) keptArchives(entries []HistoryEntry, keep int) map[string]bool {
+	kept := make(map[string]bool)
+	for _, p := range d.Personalities {
+		var hashes []string
+		add := func(hash string) {
+			for _, h := range hashes {
+				if h == hash {
+					return
+				}
+			}
+			hashes = append(hashes, hash)
+		}
+		if cur, _, err := p.Target(); err == nil {
+			add(hex.EncodeToString(cur.Hashes["sha256"]))
+		}
+		for idx := len(entries) - 1; idx >= 0 && len(hashes) < keep; idx-- {
+			e := entries[idx]
+			if e.Kind == HistoryPersonality && e.Personality == p.Name() && e.Result == HistorySuccess {
+				add(e.ToHash)
+			}
+		}
+		for _, h := range hashes {
+			kept[h] = true
+		}
+		// Not counted against keep, it's waiting to be installed
+		if staged := p.stagedHash(); len(staged) > 0 {
+			kept[staged] = true
+		}
+	}
+	return kept
+}
+
+// Finds the custom data an archive was installed with so its compose
+// project can be loaded
+func archiveCustom(entries []HistoryEntry, hash string) *DockerComposeCustom {
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		e := entries[idx]
+		if e.Kind == HistoryPersonality && e.ToHash == hash && e.Target != nil {
+			dcc, err := NotaryClient{}.DockerCompose(e.Target.Custom)
+			if err == nil {
+				return dcc
+			}
+		}
+	}
+	return nil
+}
+
+func archiveImages(cacheDir string, entries []HistoryEntry, hash string) []string {
+	dcc := archiveCustom(entries, hash)
+	if dcc == nil {
+		logrus.Debugf("Unable to find custom data for archive %s, skipping its images", hash)
+		return nil
+	}
+	project, err := loadComposeProject(cachedArchive(cacheDir, hash), hash, *dcc)
+	if err != nil {
+		logrus.Debugf("Unable to load archive %s, skipping its images: %s", hash, err)
+		return nil
+	}
+	var images []string
+	for _, svc := range project.Services {
+		images = append(images, svc.Image)
+	}
+	return images
+}
+
+// Removes archives from the docker-compose cache that aren't kept, returning
+// the images used only by the removed archives
+func (d *Device) cleanupArchives(entries []HistoryEntry, keep int) ([]string, error) {
+	cacheDir := path.Join(d.configDir, "docker-compose-cache")
+	files, err := ioutil.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read docker-compose cache: %s", err)
+	}
+
+	kept := d.keptArchives(entries, keep)
+	keptImages := make(map[string]bool)
+	for hash := range kept {
+		for _, image := range archiveImages(cacheDir, entries, hash) {
+			keptImages[image] = true
+		}
+	}
+
+	var images []string
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".tgz") {
+			continue
+		}
+		hash := strings.TrimSuffix(f.Name(), ".tgz")
+		if kept[hash] {
+			continue
+		}
+		for _, image := range archiveImages(cacheDir, entries, hash) {
+			if !keptImages[image] {
+				images = append(images, image)
+			}
+		}
+		logrus.Infof("Removing cached personality archive %s", hash)
+		if err := os.Remove(path.Join(cacheDir, f.Name())); err != nil {
+			return nil, fmt.Errorf("Unable to remove cached archive: %s", err)
+		}
+	}
+	return images, nil
+}
+
+// Removes the compose directories of personalities no longer configured
+func (d *Device) cleanupComposeDirs() error {
+	dirs := make(map[string]bool)
+	for _, p := range d.Personalities {
+		dirs[p.ComposeDir()] = true
+	}
+
+	candidates := []string{path.Join(d.configDir, "docker-compose-current")}
+	parent := path.Join(d.configDir, "personalities")
+	files, err := ioutil.ReadDir(parent)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to read personalities directory: %s", err)
+	}
+	for _, f := range files {
+		candidates = append(candidates, path.Join(parent, f.Name()))
+	}
+
+	for _, dir := range candidates {
+		if _, err := os.Stat(dir); dirs[dir] || os.IsNotExist(err) {
+			continue
+		}
+		logrus.Infof("Removing unused compose directory %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("Unable to remove compose directory: %s", err)
+		}
+	}
+	return nil
+}
+
+func cleanupImages(r Runner, images []string) {
+	for _, image := range images {
+		logrus.Infof("Removing image %s", image)
+		// Fails for images still used by a container which is what we want
+		if _, err := runWith(context.Background(), r, "", "docker", "rmi", image); err != nil {
+			logrus.Debugf("Unable to remove image %s: %s", image, err)
+		}
+	}
+	if _, err := runWith(context.Background(), r, "", "docker", "image", "prune", "-f"); err != nil {
+		logrus.Warnf("Unable to prune dangling images: %s", err)
+	}
+}
+
+func cleanupOSTree(r Runner, keepYoungerThan string) error {
+	logrus.Info("Cleaning up old OSTree deployments")
+	if err := runStreamedWith(context.Background(), r, "", "ostree", "admin", "cleanup"); err != nil {
+		return err
+	}
+	args := []string{"prune", "--refs-only"}
+	if len(keepYoungerThan) > 0 {
+		args = append(args, "--keep-younger-than="+keepYoungerThan)
+	}
+	logrus.Info("Pruning unreferenced OSTree objects")
+	return runStreamedWith(context.Background(), r, "", "ostree", args...)
+}
+
+// Cleanup frees disk space used by old personality archives, their images,
+// compose directories of removed personalities and old OSTree objects
+func (d *Device) Cleanup(opts CleanupOptions) error {
+	entries, err := d.History().Entries()
+	if err != nil {
+		return err
+	}
+	images, err := d.cleanupArchives(entries, opts.KeepArchives)
+	if err != nil {
+		return err
+	}
+	if err := d.cleanupComposeDirs(); err != nil {
+		return err
+	}
+	if !opts.SkipDocker {
+		cleanupImages(d.Runner, images)
+	}
+	if !opts.SkipOSTree {
+		if err := cleanupOSTree(d.Runner, opts.OSTreeKeepYoungerThan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
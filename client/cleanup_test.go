@@ -0,0 +1,81 @@
+package client
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleanup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, p := newPlanDevice(t, dir)
+
+	if err := os.MkdirAll(p.CacheDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	history := d.History()
+	hashes := []string{"01", "02", "03", "04"}
+	for _, hash := range hashes {
+		entry := newHistoryEntry(HistoryPersonality, nil, newTestTarget("v"+hash, hash, `{}`), "manual")
+		entry.Personality = p.Name()
+		history.record(entry, nil)
+		if err := ioutil.WriteFile(cachedArchive(p.CacheDir(), hash), []byte(hash), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The installed personality
+	cur, _, err := p.Target()
+	if err != nil {
+		t.Fatal(err)
+	}
+	curHash := hex.EncodeToString(cur.Hashes["sha256"])
+	if err := ioutil.WriteFile(cachedArchive(p.CacheDir(), curHash), []byte("cur"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Staged outside a maintenance window, waiting to be installed
+	staged := newTestTarget("v05", "05", `{}`)
+	if err := saveTarget(p.stagedFile(), staged); err != nil {
+		t.Fatal(err)
+	}
+	stagedHash := hex.EncodeToString(staged.Hashes["sha256"])
+	if err := ioutil.WriteFile(cachedArchive(p.CacheDir(), stagedHash), []byte("staged"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stray := path.Join(dir, "personalities", "removed")
+	if err := os.MkdirAll(stray, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(p.ComposeDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CleanupOptions{KeepArchives: 2, SkipDocker: true, SkipOSTree: true}
+	if err := d.Cleanup(opts); err != nil {
+		t.Fatalf("Cleanup failed: %s", err)
+	}
+
+	for _, hash := range []string{curHash, "04", stagedHash} {
+		if _, err := os.Stat(cachedArchive(p.CacheDir(), hash)); err != nil {
+			t.Errorf("Archive %s should have been kept: %s", hash, err)
+		}
+	}
+	for _, hash := range []string{"01", "02", "03"} {
+		if _, err := os.Stat(cachedArchive(p.CacheDir(), hash)); !os.IsNotExist(err) {
+			t.Errorf("Archive %s should have been removed", hash)
+		}
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Error("Unused compose directory should have been removed")
+	}
+	if _, err := os.Stat(p.ComposeDir()); err != nil {
+		t.Errorf("Personality's compose directory should have been kept: %s", err)
+	}
+}
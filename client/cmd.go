@@ -1,52 +1,145 @@
 package client
 
 import (
-	"fmt"
+	"bytes"
+	"context"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
 )
 
-//Allows tests to mock this command
-var execCommand = exec.Command
+// Command describes a subprocess for a Runner
+type Command struct {
+	Name string
+	Args []string
+	// The directory to run from, the current one when empty
+	Dir string
+	// Added to the environment tuftree runs with
+	Env []string
+	// Where output is streamed to. When both are nil the combined output is
+	// returned by Run instead.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// The command line as it would be typed, e.g. "ostree admin status"
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs the ostree, docker and docker-compose commands tuftree drives.
+// It returns an *ExecError when a command fails or ctx is done before it
+// completes.
+type Runner interface {
+	Run(ctx context.Context, cmd *Command) (string, error)
+}
+
+// ExecRunner is the Runner executing real subprocesses
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, c *Command) (string, error) {
+	cmd := exec.Command(c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	var buf bytes.Buffer
+	captured := c.Stdout == nil && c.Stderr == nil
+	if captured {
+		cmd.Stdout = &buf
+		cmd.Stderr = &buf
+	} else {
+		// Kept for the error so failures can be told apart
+		cmd.Stdout = c.Stdout
+		cmd.Stderr = &buf
+		if c.Stderr != nil {
+			cmd.Stderr = io.MultiWriter(c.Stderr, &buf)
+		}
+	}
+	if err := runCmd(ctx, cmd); err != nil {
+		return "", &ExecError{cmd.Args, err, buf.String()}
+	}
+	if !captured {
+		return "", nil
+	}
+	return buf.String(), nil
+}
 
 func errorIndent(content string) string {
 	return "| " + strings.Replace(content, "\n", "\n| ", -1) + "_"
 }
 
-func RunFrom(fromDir string, command string, args ...string) (string, error) {
-	cmd := execCommand(command, args...)
-	cmd.Dir = fromDir
-	binaryOut, err := cmd.CombinedOutput()
-	out := string(binaryOut)
-	if err != nil {
-		return "", fmt.Errorf("Unable to run '%s'. err(%s), output=\n%s",
-			cmd.Args, err, errorIndent(out))
+// Runs cmd until it completes or ctx is done, in which case the command
+// is killed and the context's error returned
+func runCmd(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
 	}
+	return err
+}
 
-	return out, nil
+// Runs a command with r, returning its combined output
+func runWith(ctx context.Context, r Runner, fromDir string, command string, args ...string) (string, error) {
+	return r.Run(ctx, &Command{Name: command, Args: args, Dir: fromDir})
+}
+
+// Runs a command with r, streaming its output to ours
+func runStreamedWith(ctx context.Context, r Runner, fromDir string, command string, args ...string) error {
+	_, err := r.Run(ctx, &Command{Name: command, Args: args, Dir: fromDir, Stdout: os.Stdout, Stderr: os.Stderr})
+	return err
+}
+
+func RunFromContext(ctx context.Context, fromDir string, command string, args ...string) (string, error) {
+	return runWith(ctx, ExecRunner{}, fromDir, command, args...)
+}
+
+func RunFrom(fromDir string, command string, args ...string) (string, error) {
+	return RunFromContext(context.Background(), fromDir, command, args...)
+}
+
+func RunContext(ctx context.Context, command string, args ...string) (string, error) {
+	return RunFromContext(ctx, "", command, args...)
 }
 
 func Run(command string, args ...string) (string, error) {
-	return RunFrom("", command, args...)
+	return RunFromContext(context.Background(), "", command, args...)
+}
+
+func RunFromStreamedToContext(ctx context.Context, fromDir string, stdOut, stdErr io.Writer, command string, args ...string) error {
+	_, err := ExecRunner{}.Run(ctx, &Command{Name: command, Args: args, Dir: fromDir, Stdout: stdOut, Stderr: stdErr})
+	return err
 }
 
 func RunFromStreamedTo(fromDir string, stdOut, stdErr io.Writer, command string, args ...string) error {
-	cmd := execCommand(command, args...)
-	cmd.Dir = fromDir
-	cmd.Stdout = stdOut
-	cmd.Stderr = stdErr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Unable to run '%s': err=%s", cmd.Args, err)
-	}
-	return nil
+	return RunFromStreamedToContext(context.Background(), fromDir, stdOut, stdErr, command, args...)
+}
+
+func RunFromStreamedContext(ctx context.Context, fromDir string, command string, args ...string) error {
+	return runStreamedWith(ctx, ExecRunner{}, fromDir, command, args...)
 }
 
 func RunFromStreamed(fromDir string, command string, args ...string) error {
-	return RunFromStreamedTo(fromDir, os.Stdout, os.Stderr, command, args...)
+	return RunFromStreamedContext(context.Background(), fromDir, command, args...)
+}
+
+func RunStreamedContext(ctx context.Context, command string, args ...string) error {
+	return runStreamedWith(ctx, ExecRunner{}, "", command, args...)
 }
 
 func RunStreamed(command string, args ...string) error {
-	return RunFromStreamedTo("", os.Stdout, os.Stderr, command, args...)
+	return RunStreamedContext(context.Background(), command, args...)
 }
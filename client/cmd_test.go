@@ -1,45 +1,95 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"os/exec"
-	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func NewMockExec(stdout string, stderr string, rc int) func(command string, args ...string) *exec.Cmd {
-	mock := func(command string, args ...string) *exec.Cmd {
-		cs := []string{"-test.run=TestHelperProcess", "--", command}
-		cs = append(cs, args...)
-		cmd := exec.Command(os.Args[0], cs...)
-		cmd.Env = []string{
-			"GO_WANT_HELPER_PROCESS=1",
-			"GO_HELPER_PROCESS_STDOUT=" + stdout,
-			"GO_HELPER_PROCESS_STDERR=" + stderr,
-			"GO_HELPER_PROCESS_RC=" + strconv.Itoa(rc),
-		}
-		return cmd
+type fakeResult struct {
+	out string
+	err error
+}
+
+// fakeRunner records the commands it runs and returns the results scripted
+// for each command line. Unscripted commands fail.
+type fakeRunner struct {
+	mu      sync.Mutex
+	results map[string][]fakeResult
+	calls   []string
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{results: make(map[string][]fakeResult)}
+}
+
+// Scripts the result of a command line like "ostree admin status". Results
+// scripted for the same command line are returned in order with the last
+// one repeating.
+func (f *fakeRunner) on(cmdline, out string, err error) *fakeRunner {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[cmdline] = append(f.results[cmdline], fakeResult{out, err})
+	return f
+}
+
+func (f *fakeRunner) Run(ctx context.Context, c *Command) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmdline := c.String()
+	f.calls = append(f.calls, cmdline)
+	args := append([]string{c.Name}, c.Args...)
+
+	results := f.results[cmdline]
+	if len(results) == 0 {
+		return "", &ExecError{Args: args, Err: fmt.Errorf("unexpected command")}
+	}
+	res := results[0]
+	if len(results) > 1 {
+		f.results[cmdline] = results[1:]
+	}
+	if res.err != nil {
+		return "", &ExecError{args, res.err, res.out}
+	}
+	if c.Stdout != nil {
+		io.WriteString(c.Stdout, res.out)
 	}
-	return mock
+	return res.out, nil
 }
 
-func TestHelperProcess(t *testing.T) {
-	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
-		return
+// Returns the command lines run so far
+func (f *fakeRunner) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+func assertCalls(t *testing.T, f *fakeRunner, expected ...string) {
+	t.Helper()
+	calls := f.Calls()
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected commands:\n%s\n!=\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
 	}
-	out := os.Getenv("GO_HELPER_PROCESS_STDOUT")
-	if len(out) > 0 {
-		fmt.Fprintf(os.Stdout, out)
+}
+
+func TestRunContextTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
 	}
-	out = os.Getenv("GO_HELPER_PROCESS_STDERR")
-	if len(out) > 0 {
-		fmt.Fprintf(os.Stderr, out)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := RunContext(ctx, "sleep", "10")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded: %v", err)
 	}
-	out = os.Getenv("GO_HELPER_PROCESS_RC")
-	if len(out) > 0 {
-		i, _ := strconv.Atoi(out)
-		os.Exit(i)
+	if time.Since(start) > 5*time.Second {
+		t.Error("Command was not killed when the context expired")
 	}
-	os.Exit(0)
 }
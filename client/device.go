@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
@@ -14,6 +15,12 @@ import (
 )
 
 func DeviceInitialize(configDir string, config DeviceConfig) (*Device, error) {
+	return DeviceInitializeWithRunner(configDir, config, ExecRunner{})
+}
+
+// DeviceInitializeWithRunner saves the configuration of a new device whose
+// commands are run by r
+func DeviceInitializeWithRunner(configDir string, config DeviceConfig, r Runner) (*Device, error) {
 	configFile := path.Join(configDir, "config.json")
 
 	if len(config.HardwareId) == 0 {
@@ -22,79 +29,143 @@ func DeviceInitialize(configDir string, config DeviceConfig) (*Device, error) {
 		if err := os.MkdirAll(trustDir, 0700); err != nil {
 			return nil, fmt.Errorf("Unable to create config-dir: %s", err)
 		}
-		tgt := probeTarget(config, trustDir)
-		_, config.HardwareId = BaseVersionSplit(tgt.Name)
+		tgt, err := probeTarget(r, config, configDir)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to probe hardware ID, you'll need to set this manually: %w", err)
+		}
+		_, config.HardwareId, err = BaseVersionSplit(tgt.Name)
+		if err != nil {
+			return nil, err
+		}
 
 		if err := saveTarget(path.Join(configDir, "base.json"), tgt); err != nil {
 			return nil, err
 		}
 	}
 
-	data, err := json.Marshal(config)
-	if err != nil {
-		return nil, fmt.Errorf("Unable create configuration: %s", err)
+	if _, err := newPersonalities(configDir, config); err != nil {
+		return nil, err
 	}
-
-	err = ioutil.WriteFile(configFile, data, 0640)
-	if err != nil {
-		return nil, fmt.Errorf("Unable write configuration: %s", err)
+	if len(config.Channel) > 0 && !channelRe.MatchString(config.Channel) {
+		return nil, fmt.Errorf("Invalid channel name '%s'", config.Channel)
+	}
+	if len(config.DeviceId) == 0 {
+		var err error
+		if config.DeviceId, err = newDeviceId(); err != nil {
+			return nil, fmt.Errorf("Unable to generate a device ID: %s", err)
+		}
+	}
+	if err := saveConfig(configFile, config); err != nil {
+		return nil, err
 	}
 
-	return NewDevice(configDir)
+	return NewDeviceWithRunner(configDir, r)
 }
 
 func NewDevice(configDir string) (*Device, error) {
+	return NewDeviceWithRunner(configDir, ExecRunner{})
+}
+
+// Reads the config.json saved by DeviceInitialize
+func loadConfig(configDir string) (DeviceConfig, error) {
+	config := DeviceConfig{}
 	configFile := path.Join(configDir, "config.json")
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		return nil, fmt.Errorf("'initialize' has not been run")
+		return config, &NotInitializedError{configDir}
 	}
 	bytes, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		logrus.Fatalf("Error reading %s: %s", configFile, err)
+		return config, fmt.Errorf("Error reading %s: %s", configFile, err)
 	}
-	config := DeviceConfig{}
-	err = json.Unmarshal(bytes, &config)
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return config, fmt.Errorf("Error in %s: %s", configFile, err)
+	}
+	return config, nil
+}
+
+// NewDeviceWithRunner loads a device whose commands are run by r
+func NewDeviceWithRunner(configDir string, r Runner) (*Device, error) {
+	configFile := path.Join(configDir, "config.json")
+	config, err := loadConfig(configDir)
 	if err != nil {
-		logrus.Fatalf("Error in %s: %s", configFile, err)
+		return nil, err
 	}
 
-	status, err := NewOSTreeStatus()
+	status, err := NewOSTreeStatus(r)
 	if err != nil {
 		return nil, err
 	}
 
 	d := Device{
+		Runner:       r,
 		HardwareId:   config.HardwareId,
 		configDir:    configDir,
 		Config:       config,
 		OSTreeStatus: status,
 	}
 
-	trustDir := path.Join(configDir, "notary")
 	if len(config.BaseCollectionName) > 0 {
-		d.BaseNotary = &NotaryClient{
-			trustDir:   trustDir,
-			serverURL:  config.BaseNotaryServerUrl,
-			rootCAFile: config.BaseNotaryCAFile,
+		d.BaseNotary, err = newBaseNotary(configDir, config)
+		if err != nil {
+			return nil, fmt.Errorf("Error in %s: %s", configFile, err)
 		}
 	}
-	if len(config.PersonalityCollectionName) > 0 {
-		d.PersonalityNotary = &NotaryClient{
-			trustDir:   trustDir,
-			serverURL:  config.PersonalityNotaryServerUrl,
-			rootCAFile: config.PersonalityNotaryCAFile,
-		}
+	d.Personalities, err = newPersonalities(configDir, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error in %s: %s", configFile, err)
 	}
+	d.setRunner(r)
 
 	return &d, nil
 }
 
+// Shares the device's runner with its personalities
+func (d *Device) setRunner(r Runner) {
+	d.Runner = r
+	for _, p := range d.Personalities {
+		p.Runner = r
+	}
+}
+
+// Looks up a personality by name
+func (d *Device) Personality(name string) (*Personality, error) {
+	for _, p := range d.Personalities {
+		if p.Config.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("Device has no personality named '%s'", name)
+}
+
+// Adds a named personality to the device and saves the configuration
+func (d *Device) AddPersonality(config PersonalityConfig) (*Personality, error) {
+	newConfig := d.Config
+	newConfig.Personalities = append(append([]PersonalityConfig{}, d.Config.Personalities...), config)
+	personalities, err := newPersonalities(d.configDir, newConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveConfig(path.Join(d.configDir, "config.json"), newConfig); err != nil {
+		return nil, err
+	}
+	d.Config = newConfig
+	d.Personalities = personalities
+	d.setRunner(d.Runner)
+	return personalities[len(personalities)-1], nil
+}
+
 func (d *Device) BaseTargets() ([]*client.TargetWithRole, error) {
-	return d.BaseNotary.Targets(d.Config.BaseCollectionName)
+	return d.BaseTargetsContext(context.Background())
+}
+
+func (d *Device) BaseTargetsContext(ctx context.Context) ([]*client.TargetWithRole, error) {
+	return d.BaseNotary.TargetsContext(ctx, d.Config.BaseCollectionName)
 }
 
-func (d *Device) PersonalityTargets() ([]*client.TargetWithRole, error) {
-	return d.PersonalityNotary.Targets(d.Config.PersonalityCollectionName)
+// BaseTargetList lists the base targets, falling back to the cached TUF
+// metadata when the notary server can't be reached
+func (d *Device) BaseTargetList(ctx context.Context) (*TargetList, error) {
+	return d.BaseNotary.ListTargets(ctx, d.Config.BaseCollectionName)
 }
 
 func (d *Device) BaseTarget() (*client.TargetWithRole, *OSTreeCustom, error) {
@@ -116,26 +187,13 @@ func (d *Device) BaseTarget() (*client.TargetWithRole, *OSTreeCustom, error) {
 	return &target, ostree, nil
 }
 
-func (d *Device) PersonalityTarget() (*client.TargetWithRole, *DockerComposeCustom, error) {
-	bytes, err := ioutil.ReadFile(path.Join(d.configDir, "personality.json"))
-	if err != nil {
-		return nil, nil, fmt.Errorf("Unable to find configured personality target: %s", err)
-	}
-	target := client.TargetWithRole{}
-	if err := json.Unmarshal(bytes, &target); err != nil {
-		return nil, nil, fmt.Errorf("Unable to parse configured personality target: %s", err)
-	}
-	if target.Custom == nil || target.Name == "" {
-		return nil, nil, fmt.Errorf("Invalid base target data: %s", bytes)
-	}
-	dcc, err := NotaryClient{}.DockerCompose(target.Custom)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Invalid DOCKER_COMPOSE custom data: %s", err)
-	}
-	return &target, dcc, nil
+func (d *Device) UpdateBase(target *client.TargetWithRole) error {
+	return d.UpdateBaseContext(context.Background(), target)
 }
 
-func (d *Device) UpdateBase(target *client.TargetWithRole) error {
+// UpdateBaseContext pulls and deploys the target's OSTree commit, aborting
+// once ctx is done
+func (d *Device) UpdateBaseContext(ctx context.Context, target *client.TargetWithRole) error {
 	desired := hex.EncodeToString(target.Hashes["sha256"])
 	if d.OSTreeStatus.Active == desired {
 		logrus.Infof("Device already running ostree hash %s", desired)
@@ -144,113 +202,119 @@ func (d *Device) UpdateBase(target *client.TargetWithRole) error {
 		}
 		return nil
 	}
-
-	ver, hwid := BaseVersionSplit(target.Name)
-	if hwid != d.HardwareId {
-		logrus.Fatalf("Unexpected hardware id for this update: %s", hwid)
-	}
-
-	custom, err := d.BaseNotary.OSTree(target.Custom)
-	if err != nil {
-		return err
+	if d.basePending(desired) {
+		logrus.Infof("ostree hash %s is already deployed, a reboot is required to run it", desired)
+		return saveTarget(d.pendingBaseFile(), target)
 	}
 
-	logrus.Infof("Updating device to version %s, ostree hash %s", ver, desired)
-	if err := OSTreeAddRemote("tuftree", custom.Url, true); err != nil {
+	if err := d.pullBase(ctx, target); err != nil {
 		return err
 	}
-	if err := OSTreeUpdate("tuftree", desired); err != nil {
+	logrus.Infof("Deploying ostree hash %s", desired)
+	if err := OSTreeDeployContext(ctx, d.Runner, desired); err != nil {
 		return err
 	}
-	if err := saveTarget(path.Join(d.configDir, "base.json"), target); err != nil {
-		return err
-	}
-	return nil
+	// base.json is only updated once FinalizeBase sees the new deployment
+	// running after a reboot
+	return saveTarget(d.pendingBaseFile(), target)
 }
 
-func (d *Device) UpdatePersonality(target *client.TargetWithRole) error {
+// StageBaseContext pulls the target's OSTree commit without deploying it.
+// The commit is referenced by OSTreeStagedRef so cleanup keeps it.
+func (d *Device) StageBaseContext(ctx context.Context, target *client.TargetWithRole) error {
 	desired := hex.EncodeToString(target.Hashes["sha256"])
-
-	composeDir := path.Join(d.configDir, "docker-compose-current")
-	if err := os.MkdirAll(composeDir, 0700); err != nil {
-		return fmt.Errorf("Unable to create docker-compose directory: %s", err)
-	}
-
-	cacheDir := path.Join(d.configDir, "docker-compose-cache")
-	if err := os.MkdirAll(cacheDir, 0700); err != nil {
-		return fmt.Errorf("Unable to create docker-compose cache: %s", err)
+	if d.OSTreeStatus.Active == desired {
+		return nil
 	}
-
-	custom, err := d.PersonalityNotary.DockerCompose(target.Custom)
-	if err != nil {
+	if err := d.pullBase(ctx, target); err != nil {
 		return err
 	}
+	return OSTreeSetRef(ctx, d.Runner, OSTreeStagedRef, desired)
+}
 
-	logrus.Infof("Updating personality to version %s, ostree hash %s", target.Name, desired)
-	new, err := NewComposeUpdater(d.PersonalityNotary.serverURL, cacheDir, desired, *custom)
+// Configures the remote of the target's OSTree commit and pulls it
+func (d *Device) pullBase(ctx context.Context, target *client.TargetWithRole) error {
+	desired := hex.EncodeToString(target.Hashes["sha256"])
+	ver, hwid, err := BaseVersionSplit(target.Name)
 	if err != nil {
 		return err
 	}
+	if hwid != d.HardwareId {
+		return &HardwareMismatchError{target.Name, d.HardwareId, hwid}
+	}
 
-	oldTgt, custom, err := d.PersonalityTarget()
+	custom, err := d.BaseNotary.OSTree(target.Custom)
 	if err != nil {
-		logrus.Warnf("Error loading current personality, assuming initial run: %s", err)
-	} else {
-		hash := hex.EncodeToString(oldTgt.Hashes["sha256"])
-		old, err := NewComposeUpdater(d.PersonalityNotary.serverURL, cacheDir, hash, *custom)
-		if err != nil {
-			logrus.Warnf("Unable to load old personality, skipping docker-compose-stop: %s", err)
-		} else {
-			logrus.Info("Stopping old set of docker-compose containers")
-			if err := old.Stop(composeDir); err != nil {
-				logrus.Warnf("Unable to stop old personality, continuing with fingers crossed: %s", err)
-			}
-		}
+		return err
 	}
 
-	logrus.Info("Starting new docker-compose containers")
-	if err := new.Start(composeDir); err != nil {
-		return fmt.Errorf("Unable to start new personality: %s", err)
+	logrus.Infof("Fetching version %s, ostree hash %s", ver, desired)
+	certFile, keyFile := d.Config.tlsFiles("").identity()
+	remotesDir := d.OSTreeRemotesDir
+	if len(remotesDir) == 0 {
+		remotesDir = DefaultOSTreeRemotesDir
 	}
-	if err := saveTarget(path.Join(d.configDir, "personality.json"), target); err != nil {
+	if err := OSTreeAddRemote(remotesDir, "tuftree", custom.Url, true, certFile, keyFile); err != nil {
 		return err
 	}
-	return nil
+	return OSTreePullContext(ctx, d.Runner, "tuftree", desired)
 }
 
 // Takes a target name from a Base image collection like v38-hikey
 // and returns a tuple(version, hardwareId)
-func BaseVersionSplit(targetName string) (string, string) {
+func BaseVersionSplit(targetName string) (string, string, error) {
 	idx := strings.Index(targetName, "-")
 	if idx < 1 {
-		logrus.Fatalf("Invalid target name: %s. Must be formatted as <version>-<hardwareId>", targetName)
+		return "", "", fmt.Errorf("Invalid target name: %s. Must be formatted as <version>-<hardwareId>", targetName)
 	}
-	return targetName[:idx], targetName[idx+1:]
+	return targetName[:idx], targetName[idx+1:], nil
 }
 
-func probeTarget(config DeviceConfig, trustDir string) *client.TargetWithRole {
-	notary := NotaryClient{
-		trustDir:   trustDir,
-		serverURL:  config.BaseNotaryServerUrl,
-		rootCAFile: config.BaseNotaryCAFile,
+func newBaseNotary(configDir string, config DeviceConfig) (*NotaryClient, error) {
+	trustDir := path.Join(configDir, "notary")
+	h := config.httpClients(configDir, config.BaseNotaryServerUrl, config.BaseNotaryCAFile)
+	notary, err := newNotaryClient(trustDir, config.BaseNotaryServerUrl, h, config.BaseAllowedRoles)
+	if err != nil {
+		return nil, fmt.Errorf("Base: %s", err)
+	}
+	return notary, nil
+}
+
+func probeTarget(r Runner, config DeviceConfig, configDir string) (*client.TargetWithRole, error) {
+	notary, err := newBaseNotary(configDir, config)
+	if err != nil {
+		return nil, err
 	}
 	targets, err := notary.Targets(config.BaseCollectionName)
 	if err != nil {
-		logrus.Fatalf("Unable to probe hardware ID, you'll need to set this manually: error=%s", err)
+		return nil, err
 	}
 
-	status, err := NewOSTreeStatus()
+	status, err := NewOSTreeStatus(r)
 	if err != nil {
-		logrus.Fatalf("Unable to probe hardware ID, you'll need to set this manually: error=%s", err)
+		return nil, err
 	}
 
 	for _, target := range targets {
 		hash := hex.EncodeToString(target.Hashes["sha256"])
 		if hash == status.Active {
-			return target
+			return target, nil
 		}
 	}
-	logrus.Fatalf("Unable to find device's hash(%s) in known updates", status.Active)
+	err = fmt.Errorf("Unable to find device's hash(%s) in known updates", status.Active)
+	return nil, &TrustError{config.BaseCollectionName, err}
+}
+
+func saveConfig(fileName string, config DeviceConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("Unable create configuration: %s", err)
+	}
+
+	err = ioutil.WriteFile(fileName, data, 0640)
+	if err != nil {
+		return fmt.Errorf("Unable write configuration: %s", err)
+	}
 	return nil
 }
 
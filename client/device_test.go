@@ -1,20 +1,50 @@
 package client
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
 )
 
 func TestBaseVersionSplit(t *testing.T) {
-	ver, hwid := BaseVersionSplit("v123-intel")
+	ver, hwid, err := BaseVersionSplit("v123-intel")
+	if err != nil {
+		t.Fatal(err)
+	}
 	if ver != "v123" {
 		t.Errorf("Invalid version %s != v123", ver)
 	}
 	if hwid != "intel" {
 		t.Errorf("Invalid hwid %s != intel", hwid)
 	}
+	if _, _, err := BaseVersionSplit("v123"); err == nil {
+		t.Error("Target names without a hardware id should fail")
+	}
+}
+
+func TestNewDeviceNotInitialized(t *testing.T) {
+	_, err := NewDevice("/does/not/exist")
+	var notInit *NotInitializedError
+	if !errors.As(err, &notInit) {
+		t.Fatalf("Expected NotInitializedError: %v", err)
+	}
+}
+
+func TestUpdateBaseHardwareMismatch(t *testing.T) {
+	target := &client.TargetWithRole{}
+	target.Name = "v124-arm"
+	target.Hashes = data.Hashes{"sha256": []byte{1}}
+	d := Device{HardwareId: "intel", OSTreeStatus: &OSTreeStatus{Active: "00"}}
+	err := d.UpdateBase(target)
+	var hwErr *HardwareMismatchError
+	if !errors.As(err, &hwErr) || hwErr.Found != "arm" {
+		t.Fatalf("Expected HardwareMismatchError: %v", err)
+	}
 }
 
 func TestBaseTarget(t *testing.T) {
@@ -72,8 +102,8 @@ func TestPersonalityTarget(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	d := Device{configDir: dir}
-	tgt, dcc, err := d.PersonalityTarget()
+	p := Personality{configDir: dir, Config: PersonalityConfig{Name: DefaultPersonality}}
+	tgt, dcc, err := p.Target()
 	if err != nil {
 		t.Fatalf("Unable to parse base.json: %s", err)
 	}
@@ -84,3 +114,55 @@ func TestPersonalityTarget(t *testing.T) {
 		t.Errorf("Invalid tgz url: %s != http://example.com", dcc.TgzUrl)
 	}
 }
+
+func TestPersonalities(t *testing.T) {
+	config := DeviceConfig{
+		PersonalityCollectionName: "legacy",
+		Personalities: []PersonalityConfig{
+			{Name: "vendor", CollectionName: "vendor-collection"},
+		},
+	}
+	personalities, err := newPersonalities("/tmp", config)
+	if err != nil {
+		t.Fatalf("Unable to load personalities: %s", err)
+	}
+	if len(personalities) != 2 {
+		t.Fatalf("Expected 2 personalities, found %d", len(personalities))
+	}
+	legacy, vendor := personalities[0], personalities[1]
+	if legacy.Name() != DefaultPersonality || legacy.StateFile() != "/tmp/personality.json" {
+		t.Errorf("Invalid legacy personality: %s %s", legacy.Name(), legacy.StateFile())
+	}
+	if legacy.ComposeDir() != "/tmp/docker-compose-current" {
+		t.Errorf("Invalid legacy compose dir: %s", legacy.ComposeDir())
+	}
+	if vendor.StateFile() != "/tmp/personality-vendor.json" {
+		t.Errorf("Invalid state file: %s", vendor.StateFile())
+	}
+	if vendor.ComposeDir() != "/tmp/personalities/vendor" {
+		t.Errorf("Invalid compose dir: %s", vendor.ComposeDir())
+	}
+
+	config.Personalities = append(config.Personalities, PersonalityConfig{Name: "vendor", CollectionName: "foo"})
+	if _, err := newPersonalities("/tmp", config); err == nil {
+		t.Error("Duplicate personality names should fail")
+	}
+	config.Personalities = []PersonalityConfig{{Name: "../foo", CollectionName: "foo"}}
+	if _, err := newPersonalities("/tmp", config); err == nil {
+		t.Error("Invalid personality names should fail")
+	}
+}
+
+func TestDeviceInitializeWithRunner(t *testing.T) {
+	t.Parallel()
+	status := "* lmp 1234.0\n    Version: 1\n"
+	runner := newFakeRunner().on("ostree admin status", status, nil)
+	d, err := DeviceInitializeWithRunner(t.TempDir(), DeviceConfig{HardwareId: "intel"}, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Runner != runner || d.OSTreeStatus.Active != "1234" {
+		t.Errorf("Device should use the injected runner: %v", d.OSTreeStatus)
+	}
+	assertCalls(t, runner, "ostree admin status")
+}
@@ -1,9 +1,7 @@
 package client
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -19,42 +17,72 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func NewComposeUpdater(notaryUrl, cacheDir, hash string, dcc DockerComposeCustom) (*DockerComposeUpdater, error) {
-	tgzFile := path.Join(cacheDir, hash) + ".tgz"
+// The location of a personality archive in the docker-compose cache
+func cachedArchive(cacheDir, hash string) string {
+	return path.Join(cacheDir, hash) + ".tgz"
+}
+
+func NewComposeUpdater(r Runner, notary *NotaryClient, cacheDir, hash string, dcc DockerComposeCustom) (*DockerComposeUpdater, error) {
+	return NewComposeUpdaterContext(context.Background(), r, notary, cacheDir, hash, dcc)
+}
+
+// NewComposeUpdaterContext downloads the archive if it isn't cached and
+// pulls its images. Downloads and pulls are aborted once ctx is done or
+// their phase's timeout expires.
+func NewComposeUpdaterContext(ctx context.Context, r Runner, notary *NotaryClient, cacheDir, hash string, dcc DockerComposeCustom) (*DockerComposeUpdater, error) {
+	tgzFile := cachedArchive(cacheDir, hash)
 	if _, err := os.Stat(tgzFile); os.IsNotExist(err) {
-		logrus.Infof("DOCKER_COMPOSE(%s) not cached locally, downloading now", hash)
-		if err := downloadTo(tgzFile, dcc.TgzUrl, hash); err != nil {
+		if err := fetchArchive(ctx, notary, tgzFile, hash, dcc); err != nil {
 			return nil, err
 		}
 	}
 
-	reader, err := validateTgz(tgzFile, hash)
+	project, err := loadComposeProject(tgzFile, hash, dcc)
 	if err != nil {
 		return nil, err
 	}
-	composeFiles, err := composeFiles(dcc.TgzLeading, dcc.ComposeFiles, reader)
-	if err != nil {
+	if err := validateComposeImages(ctx, r, notary.serverURL, project); err != nil {
 		return nil, err
 	}
+	return &DockerComposeUpdater{runner: r, cachedTgz: tgzFile, dcc: dcc}, nil
+}
 
-	if err := validateComposeImages(notaryUrl, composeFiles, dcc.ComposeEnv); err != nil {
-		return nil, err
+func fetchArchive(ctx context.Context, notary *NotaryClient, tgzFile, hash string, dcc DockerComposeCustom) error {
+	if len(dcc.OCIArtifact) > 0 {
+		logrus.Infof("DOCKER_COMPOSE(%s) not cached locally, pulling %s now", hash, dcc.OCIArtifact)
+	} else {
+		logrus.Infof("DOCKER_COMPOSE(%s) not cached locally, downloading now", hash)
 	}
-	return &DockerComposeUpdater{cachedTgz: tgzFile, dcc: dcc}, nil
+	return retry(ctx, "Downloading DOCKER_COMPOSE("+hash+")", func() error {
+		ctx, cancel := phaseContext(ctx, downloadPhase)
+		defer cancel()
+		if len(dcc.OCIArtifact) > 0 {
+			return pullArtifact(ctx, tgzFile, dcc.OCIArtifact, notary.http, hash)
+		}
+		return downloadTo(ctx, notary.http, tgzFile, dcc.TgzUrl, hash)
+	})
 }
 
 func (dcu *DockerComposeUpdater) Stop(projectDir string) error {
-	return dcu.run(projectDir, "stop")
+	return dcu.StopContext(context.Background(), projectDir)
+}
+
+func (dcu *DockerComposeUpdater) StopContext(ctx context.Context, projectDir string) error {
+	return dcu.run(ctx, projectDir, "stop")
 }
 
 func (dcu *DockerComposeUpdater) Start(projectDir string) error {
-	return dcu.run(projectDir, "up", "-d")
+	return dcu.StartContext(context.Background(), projectDir)
+}
+
+func (dcu *DockerComposeUpdater) StartContext(ctx context.Context, projectDir string) error {
+	return dcu.run(ctx, projectDir, "up", "-d")
 }
 
-func (dcu *DockerComposeUpdater) run(projectDir string, args ...string) error {
+func (dcu *DockerComposeUpdater) run(ctx context.Context, projectDir string, args ...string) error {
 	// Ensure our docker-compose directory has the files we expect
 	logrus.Infof("Extracting docker-compose to %s", projectDir)
-	if err := extractFile(dcu.cachedTgz, projectDir, dcu.dcc.TgzLeading); err != nil {
+	if err := extractFile(dcu.cachedTgz, projectDir, dcu.dcc.ArchiveFormat, dcu.dcc.TgzLeading); err != nil {
 		return fmt.Errorf("Unable to extract docker-compose tarball: %s", err)
 	}
 
@@ -67,24 +95,42 @@ func (dcu *DockerComposeUpdater) run(projectDir string, args ...string) error {
 		}
 	}
 	args = append(fileArgs, args...)
-	return RunFromStreamed(projectDir, "docker-compose", args...)
+	ctx, cancel := phaseContext(ctx, composePhase)
+	defer cancel()
+	return runStreamedWith(ctx, dcu.runner, projectDir, "docker-compose", args...)
 }
 
-func downloadTo(dstFile, url, hash string) error {
-	resp, err := http.Get(url)
+func downloadTo(ctx context.Context, h httpClients, dstFile, url, hash string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("Unable to download %s : %s", url, err)
+		return err
+	}
+	client, err := h.client(url)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &NetworkError{Url: url, Err: err}
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return &NetworkError{Url: url, Err: fmt.Errorf("HTTP_%d", resp.StatusCode), StatusCode: resp.StatusCode}
+	}
 
 	buf, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Unable to read response from %s : %s", url, err)
+		return &NetworkError{Url: url, Err: err}
 	}
+	return saveVerified(dstFile, url, buf, hash)
+}
+
+// Writes buf to dstFile if its sha256 matches the expected hash
+func saveVerified(dstFile, source string, buf []byte, hash string) error {
 	sum := sha256.Sum256(buf)
 	found := hex.EncodeToString(sum[:])
 	if found != hash {
-		return fmt.Errorf("Invalid sha256(%s) %s != %s", url, found, sum)
+		return &HashMismatchError{source, hash, found}
 	}
 
 	if err := ioutil.WriteFile(dstFile, buf, 0640); err != nil {
@@ -93,7 +139,7 @@ func downloadTo(dstFile, url, hash string) error {
 	return nil
 }
 
-func validateTgz(tgzFile, hash string) (*tar.Reader, error) {
+func validateArchive(tgzFile, hash, format string) (archiveReader, error) {
 	buf, err := ioutil.ReadFile(tgzFile)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to read DOCKER_COMPOSE cache of %s: %s", tgzFile, err)
@@ -101,17 +147,17 @@ func validateTgz(tgzFile, hash string) (*tar.Reader, error) {
 	sum := sha256.Sum256(buf)
 	found := hex.EncodeToString(sum[:])
 	if found != hash {
-		return nil, fmt.Errorf("DOCKER_COMPOSE cache changed on disk sha256(%s) %s != %s", tgzFile, found, hash)
+		return nil, &HashMismatchError{tgzFile, hash, found}
 	}
 
-	gzf, err := gzip.NewReader(bytes.NewReader(buf))
+	reader, err := newArchiveReader(buf, format)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to decompress DOCKER_COMPOSE cache %s: %s", tgzFile, err)
+		return nil, fmt.Errorf("Unable to open DOCKER_COMPOSE cache %s: %s", tgzFile, err)
 	}
-	return tar.NewReader(gzf), nil
+	return reader, nil
 }
 
-func composeFiles(stripLeading bool, composeFiles []string, tr *tar.Reader) ([]types.ConfigFile, error) {
+func composeFiles(stripLeading bool, composeFiles []string, tr archiveReader) ([]types.ConfigFile, error) {
 	if len(composeFiles) == 0 {
 		composeFiles = []string{"docker-compose.yml"}
 	}
@@ -125,6 +171,9 @@ func composeFiles(stripLeading bool, composeFiles []string, tr *tar.Reader) ([]t
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read archive data: %s", err)
+		}
 		if stripLeading {
 			idx := strings.Index(header.Name, "/")
 			if idx > 0 {
@@ -135,14 +184,14 @@ func composeFiles(stripLeading bool, composeFiles []string, tr *tar.Reader) ([]t
 		if ok {
 			delete(required, header.Name)
 			data := make([]byte, header.Size)
-			_, err := tr.Read(data)
-			if err != nil && err != io.EOF {
-				return nil, fmt.Errorf("Error reading %s from tgz data: %s", header.Name, err)
+			_, err := io.ReadFull(tr, data)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading %s from archive data: %s", header.Name, err)
 			}
 
 			dict, err := loader.ParseYAML(data)
 			if err != nil {
-				return nil, fmt.Errorf("Invalid docker-compose(%s) in tgz data: %s", header.Name, err)
+				return nil, fmt.Errorf("Invalid docker-compose(%s) in archive data: %s", header.Name, err)
 			}
 			files = append(files, types.ConfigFile{Filename: header.Name, Config: dict})
 		}
@@ -152,30 +201,45 @@ func composeFiles(stripLeading bool, composeFiles []string, tr *tar.Reader) ([]t
 		for name, _ := range required {
 			names = append(names, name)
 		}
-		return nil, fmt.Errorf("Missing required compose files in tgz data: %s", names)
+		return nil, fmt.Errorf("Missing required compose files in archive data: %s", names)
 	}
 	return files, nil
 }
 
-func validateComposeImages(notaryUrl string, composeFiles []types.ConfigFile, env map[string]string) error {
+// Loads the docker-compose project described by a cached archive
+func loadComposeProject(archive, hash string, dcc DockerComposeCustom) (*types.Config, error) {
+	reader, err := validateArchive(archive, hash, dcc.ArchiveFormat)
+	if err != nil {
+		return nil, err
+	}
+	composeFiles, err := composeFiles(dcc.TgzLeading, dcc.ComposeFiles, reader)
+	if err != nil {
+		return nil, err
+	}
+
 	workingDir, err := os.Getwd()
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Unable to determine working directory: %s", err)
 	}
 
 	config := types.ConfigDetails{
 		WorkingDir:  workingDir,
 		ConfigFiles: composeFiles,
-		Environment: env,
-	}
-	actual, err := loader.Load(config)
-	if err != nil {
-		return err
+		Environment: dcc.ComposeEnv,
 	}
-	for _, svc := range actual.Services {
-		if strings.HasPrefix(svc.Image, "hub.foundries.io") {
+	return loader.Load(config)
+}
+
+// Images from our hub are signed and can be validated with notary
+func isSignedImage(image string) bool {
+	return strings.HasPrefix(image, "hub.foundries.io")
+}
+
+func validateComposeImages(ctx context.Context, r Runner, notaryUrl string, project *types.Config) error {
+	for _, svc := range project.Services {
+		if isSignedImage(svc.Image) {
 			logrus.Infof("Pulling/validating signed image: %s", svc.Image)
-			if err := notaryPull(notaryUrl, svc.Image); err != nil {
+			if err := notaryPull(ctx, r, notaryUrl, svc.Image); err != nil {
 				return err
 			}
 		} else {
@@ -185,16 +249,28 @@ func validateComposeImages(notaryUrl string, composeFiles []types.ConfigFile, en
 	return nil
 }
 
-func notaryPull(notaryUrl, image string) error {
-	cmd := execCommand("docker", "pull", image)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(),
-		"DOCKER_CONTENT_TRUST=1",
-		"DOCKER_CONTENT_TRUST_SERVER="+notaryUrl,
-	)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Unable to run '%s': err=%s", cmd.Args, err)
+func notaryPull(ctx context.Context, r Runner, notaryUrl, image string) error {
+	// A digest identifies the content, so a local copy was verified when it
+	// was pulled and doesn't need the network
+	if strings.Contains(image, "@sha256:") {
+		if _, err := runWith(ctx, r, "", "docker", "image", "inspect", image); err == nil {
+			logrus.Infof("Image %s is already present", image)
+			return nil
+		}
 	}
-	return nil
+	return retry(ctx, "Pulling "+image, func() error {
+		ctx, cancel := phaseContext(ctx, pullPhase)
+		defer cancel()
+		_, err := r.Run(ctx, &Command{
+			Name:   "docker",
+			Args:   []string{"pull", image},
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+			Env: []string{
+				"DOCKER_CONTENT_TRUST=1",
+				"DOCKER_CONTENT_TRUST_SERVER=" + notaryUrl,
+			},
+		})
+		return err
+	})
 }
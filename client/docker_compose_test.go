@@ -3,10 +3,13 @@ package client
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -42,20 +45,21 @@ func createTgz(t *testing.T, contents map[string]string) (string, string) {
 	return tmpfile.Name(), hex.EncodeToString(sum[:])
 }
 
-func TestValidateTgz(t *testing.T) {
+func TestValidateArchive(t *testing.T) {
 	contents := map[string]string{"foo": "bar"}
 	tgz, hash := createTgz(t, contents)
 	defer os.Remove(tgz)
 
-	_, err := validateTgz(tgz, hash+"x")
-	if err == nil {
-		t.Error("validateTgz should fail with hash-mismatch")
+	_, err := validateArchive(tgz, hash+"x", "")
+	var mismatch *HashMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Errorf("validateArchive should fail with hash-mismatch: %v", err)
 	} else {
 		t.Logf("Error message: %s", err)
 	}
-	_, err = validateTgz(tgz, hash)
+	_, err = validateArchive(tgz, hash, "")
 	if err != nil {
-		t.Errorf("validateTgz failed: %s", err)
+		t.Errorf("validateArchive failed: %s", err)
 	}
 }
 
@@ -64,9 +68,9 @@ func TestComposeFiles(t *testing.T) {
 	tgz, hash := createTgz(t, contents)
 	defer os.Remove(tgz)
 
-	tr, err := validateTgz(tgz, hash)
+	tr, err := validateArchive(tgz, hash, "")
 	if err != nil {
-		t.Fatalf("validateTgz failed: %s", err)
+		t.Fatalf("validateArchive failed: %s", err)
 	}
 
 	files := []string{}
@@ -77,9 +81,9 @@ func TestComposeFiles(t *testing.T) {
 		t.Logf("Error message: %s", err)
 	}
 
-	tr, err = validateTgz(tgz, hash)
+	tr, err = validateArchive(tgz, hash, "")
 	if err != nil {
-		t.Fatalf("validateTgz failed: %s", err)
+		t.Fatalf("validateArchive failed: %s", err)
 	}
 	files = []string{"blah"}
 	_, err = composeFiles(true, files, tr)
@@ -87,3 +91,19 @@ func TestComposeFiles(t *testing.T) {
 		t.Errorf("composeFiles failed: %s", err)
 	}
 }
+
+func TestNotaryPullPresent(t *testing.T) {
+	image := "hub.foundries.io/web@sha256:" + strings.Repeat("a", 64)
+	runner := newFakeRunner().on("docker image inspect "+image, "[]", nil)
+	if err := notaryPull(context.Background(), runner, "https://notary", image); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner, "docker image inspect "+image)
+
+	// Tags can move, so they are always pulled
+	runner = newFakeRunner().on("docker pull hub.foundries.io/web:2", "", nil)
+	if err := notaryPull(context.Background(), runner, "https://notary", "hub.foundries.io/web:2"); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner, "docker pull hub.foundries.io/web:2")
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+)
+
+const (
+	DoctorOk      = "ok"
+	DoctorWarning = "warning"
+	DoctorError   = "error"
+
+	// TUF metadata expiry is checked against the local clock
+	maxClockSkew = 5 * time.Minute
+	// Free space below which updates are likely to fail
+	minDiskFree = 100 * 1024 * 1024
+)
+
+type DoctorFinding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Advice   string `json:"advice,omitempty"`
+}
+
+type doctor struct {
+	findings []DoctorFinding
+}
+
+func (d *doctor) add(check, severity, message, advice string) {
+	d.findings = append(d.findings, DoctorFinding{check, severity, message, advice})
+}
+
+func (d *doctor) checkBinaries() {
+	for _, bin := range []string{"ostree", "docker", "docker-compose"} {
+		if p, err := exec.LookPath(bin); err != nil {
+			d.add("binary "+bin, DoctorError, fmt.Sprintf("%s not found", bin), "Install "+bin+" or add it to the PATH")
+		} else {
+			d.add("binary "+bin, DoctorOk, p, "")
+		}
+	}
+}
+
+func (d *doctor) checkDisk(dir string) {
+	check := "disk " + dir
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		if !os.IsNotExist(err) {
+			d.add(check, DoctorWarning, err.Error(), "")
+		}
+		return
+	}
+	free := stat.Bavail * uint64(stat.Bsize)
+	total := stat.Blocks * uint64(stat.Bsize)
+	msg := fmt.Sprintf("%d of %d MB free", free/1024/1024, total/1024/1024)
+	if free < minDiskFree {
+		d.add(check, DoctorError, msg, "Free up space, e.g. with 'tuftree cleanup'")
+	} else if free < total/10 {
+		d.add(check, DoctorWarning, msg, "Less than 10% free, consider running 'tuftree cleanup'")
+	} else {
+		d.add(check, DoctorOk, msg, "")
+	}
+}
+
+func (d *doctor) checkServer(name, serverURL string, h httpClients) {
+	files := h.tls
+	check := fmt.Sprintf("%s notary", name)
+	if len(files.caFile) > 0 {
+		if _, err := os.Stat(files.caFile); err != nil {
+			d.add(check+" CA", DoctorError, err.Error(), "Fix the CA file path in config.json")
+			return
+		}
+	}
+	if len(files.keyFile) > 0 {
+		if _, err := os.Stat(files.keyFile); err != nil {
+			d.add(check+" client key", DoctorError, err.Error(), "Fix TLSKeyFile in config.json or run 'tuftree initialize --gen-device-key'")
+			return
+		}
+		if _, err := os.Stat(files.certFile); err != nil {
+			d.add(check+" client certificate", DoctorWarning, err.Error(), "Have the device CSR signed and save the certificate as TLSCertFile")
+		}
+	}
+	if _, err := h.hostCredentials(""); err != nil {
+		d.add(check+" credentials", DoctorError, err.Error(), "Fix or remove "+h.credentialsFile)
+		return
+	}
+	base, err := baseTransport(files)
+	if err != nil {
+		d.add(check+" CA", DoctorError, err.Error(), "The CA file must contain PEM encoded certificates")
+		return
+	}
+	resp, err := ping(context.Background(), base, serverURL)
+	if err != nil {
+		d.add(check, DoctorError, err.Error(), "Check network connectivity, proxy settings and that the CA file signed the server's certificate")
+		return
+	}
+	resp.Body.Close()
+	d.add(check, DoctorOk, fmt.Sprintf("%s reachable, HTTP_%d", serverURL, resp.StatusCode), "")
+
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		skew := time.Since(date)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxClockSkew {
+			d.add(check+" clock", DoctorError, fmt.Sprintf("Local clock is %s off from %s", skew.Round(time.Second), serverURL),
+				"TUF metadata will appear expired or not yet valid. Synchronize the clock with NTP")
+		} else {
+			d.add(check+" clock", DoctorOk, fmt.Sprintf("Within %s of %s", maxClockSkew, serverURL), "")
+		}
+	}
+}
+
+func (d *doctor) checkOSTree(r Runner) {
+	status, err := NewOSTreeStatus(r)
+	if err != nil {
+		d.add("ostree status", DoctorError, err.Error(), "tuftree must run on an OSTree based system with ostree in the PATH")
+		return
+	}
+	d.add("ostree status", DoctorOk, "Active deployment "+status.Active, "")
+}
+
+// Diagnose checks the device's configuration and environment for common
+// problems. Unlike NewDevice it doesn't fail on the first problem found.
+func Diagnose(configDir string) []DoctorFinding {
+	return diagnose(configDir, ExecRunner{})
+}
+
+func diagnose(configDir string, r Runner) []DoctorFinding {
+	doc := doctor{}
+	doc.checkBinaries()
+	doc.checkOSTree(r)
+
+	configFile := path.Join(configDir, "config.json")
+	if config, err := loadConfig(configDir); err != nil {
+		doc.add("configuration", DoctorError, err.Error(), "Run 'tuftree initialize' or fix "+configFile)
+	} else {
+		doc.add("configuration", DoctorOk, configFile, "")
+		if len(config.BaseCollectionName) > 0 {
+			if notary, err := newBaseNotary(configDir, config); err != nil {
+				doc.add("configuration", DoctorError, err.Error(), "Fix "+configFile)
+			} else {
+				doc.checkServer("base", notary.serverURL, notary.http)
+			}
+		}
+		if personalities, err := newPersonalities(configDir, config); err != nil {
+			doc.add("configuration", DoctorError, err.Error(), "Fix "+configFile)
+		} else {
+			for _, p := range personalities {
+				doc.checkServer(fmt.Sprintf("personality(%s)", p.Name()), p.Notary.serverURL, p.Notary.http)
+			}
+		}
+	}
+
+	for _, dir := range []string{configDir, "/sysroot", "/var/lib/docker"} {
+		doc.checkDisk(dir)
+	}
+	return doc.findings
+}
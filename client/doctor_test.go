@@ -0,0 +1,76 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestDoctorServer(t *testing.T) {
+	skew := time.Duration(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	doc := doctor{}
+	doc.checkServer("base", server.URL, httpClients{})
+	for _, f := range doc.findings {
+		if f.Severity != DoctorOk {
+			t.Errorf("Unexpected finding: %v", f)
+		}
+	}
+
+	skew = time.Hour
+	doc = doctor{}
+	doc.checkServer("base", server.URL, httpClients{})
+	if len(doc.findings) != 2 || doc.findings[1].Severity != DoctorError {
+		t.Errorf("Clock skew should be reported: %v", doc.findings)
+	}
+
+	doc = doctor{}
+	doc.checkServer("base", server.URL, httpClients{tls: tlsFiles{caFile: "/does/not/exist.crt"}})
+	if len(doc.findings) != 1 || doc.findings[0].Check != "base notary CA" {
+		t.Errorf("Missing CA file should be reported: %v", doc.findings)
+	}
+
+	doc = doctor{}
+	doc.checkServer("base", "http://127.0.0.1:1", httpClients{})
+	if len(doc.findings) != 1 || doc.findings[0].Severity != DoctorError {
+		t.Errorf("Unreachable server should be reported: %v", doc.findings)
+	}
+}
+
+func TestDiagnoseWithoutOSTree(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	config := DeviceConfig{
+		BaseNotaryServerUrl: server.URL,
+		BaseCollectionName:  "hub.foundries.io/lmp",
+		Personalities:       []PersonalityConfig{{Name: "apps", NotaryServerUrl: server.URL, CollectionName: "apps"}},
+	}
+	if err := saveConfig(path.Join(dir, "config.json"), config); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := newFakeRunner().on("ostree admin status", "", fmt.Errorf("exec: \"ostree\": executable file not found in $PATH"))
+	findings := make(map[string]DoctorFinding)
+	for _, f := range diagnose(dir, runner) {
+		findings[f.Check] = f
+	}
+	if f := findings["ostree status"]; f.Severity != DoctorError {
+		t.Errorf("Missing ostree should be reported: %v", f)
+	}
+	for _, check := range []string{"configuration", "base notary", "personality(apps) notary"} {
+		if f := findings[check]; f.Severity != DoctorOk {
+			t.Errorf("%s should be checked without ostree: %v", check, f)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/signed"
+)
+
+// NetworkError is returned when a notary server, registry or download URL
+// can't be reached or responds with an unexpected status
+type NetworkError struct {
+	Url string
+	Err error
+	// The HTTP status the server responded with, 0 if it didn't respond
+	StatusCode int
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("Unable to reach %s: %s", e.Url, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// TrustError is returned when TUF metadata can't be validated
+type TrustError struct {
+	Collection string
+	Err        error
+}
+
+func (e *TrustError) Error() string {
+	return fmt.Sprintf("Unable to validate TUF metadata for %s: %s", e.Collection, e.Err)
+}
+
+func (e *TrustError) Unwrap() error {
+	return e.Err
+}
+
+// ExpiredError is returned when TUF metadata is valid but has expired. This
+// is also a symptom of a device whose clock is wrong.
+type ExpiredError struct {
+	Collection string
+	Err        error
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("TUF metadata for %s has expired: %s", e.Collection, e.Err)
+}
+
+func (e *ExpiredError) Unwrap() error {
+	return e.Err
+}
+
+// HashMismatchError is returned when content doesn't match the sha256 its
+// TUF target describes
+type HashMismatchError struct {
+	Source   string
+	Expected string
+	Found    string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("Invalid sha256(%s) %s != %s", e.Source, e.Found, e.Expected)
+}
+
+// HardwareMismatchError is returned for targets built for other hardware
+type HardwareMismatchError struct {
+	Target   string
+	Expected string
+	Found    string
+}
+
+func (e *HardwareMismatchError) Error() string {
+	return fmt.Sprintf("Unexpected hardware id for %s: %s != %s", e.Target, e.Found, e.Expected)
+}
+
+// UnavailableTargetError is returned when a requested target exists but the
+// device may not update to it, e.g. because it's on another channel
+type UnavailableTargetError struct {
+	Target string
+	Reason string
+}
+
+func (e *UnavailableTargetError) Error() string {
+	return fmt.Sprintf("Target %s is not available to this device: %s", e.Target, e.Reason)
+}
+
+// NotInitializedError is returned when 'initialize' hasn't been run for a
+// configuration directory
+type NotInitializedError struct {
+	ConfigDir string
+}
+
+func (e *NotInitializedError) Error() string {
+	return fmt.Sprintf("'initialize' has not been run for %s", e.ConfigDir)
+}
+
+// ExecError is returned when a command such as ostree or docker fails
+type ExecError struct {
+	Args   []string
+	Err    error
+	Output string
+}
+
+func (e *ExecError) Error() string {
+	if len(e.Output) > 0 {
+		return fmt.Sprintf("Unable to run '%s'. err(%s), output=\n%s", e.Args, e.Err, errorIndent(e.Output))
+	}
+	return fmt.Sprintf("Unable to run '%s': err=%s", e.Args, e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// Classifies the errors notary returns while updating TUF metadata
+func notaryError(collection, serverURL string, err error) error {
+	switch err.(type) {
+	case storage.NetworkError, storage.ErrServerUnavailable:
+		return &NetworkError{Url: serverURL, Err: err}
+	case signed.ErrExpired:
+		return &ExpiredError{collection, err}
+	}
+	return &TrustError{collection, err}
+}
+
+// LockedError is returned when another process holds the lock of a
+// configuration directory
+type LockedError struct {
+	// nil if the holder couldn't be identified
+	Holder *LockHolder
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return "Another tuftree process is running"
+	}
+	if !e.Holder.Running() {
+		// flock locks are held by open files, so a child that inherited the
+		// file can outlive the holder
+		return fmt.Sprintf("Lock held on behalf of pid %d (%s) which is no longer running, a process it started may still hold it",
+			e.Holder.Pid, e.Holder.Command)
+	}
+	return fmt.Sprintf("Another tuftree process is running: pid %d (%s) since %s",
+		e.Holder.Pid, e.Holder.Command, e.Holder.Since.Local().Format(time.RFC3339))
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/signed"
+)
+
+func TestNotaryError(t *testing.T) {
+	var netErr *NetworkError
+	if err := notaryError("foo", "https://notary", storage.NetworkError{Wrapped: fmt.Errorf("timeout")}); !errors.As(err, &netErr) {
+		t.Errorf("Expected NetworkError: %v", err)
+	}
+	var expired *ExpiredError
+	if err := notaryError("foo", "https://notary", signed.ErrExpired{Role: "timestamp"}); !errors.As(err, &expired) {
+		t.Errorf("Expected ExpiredError: %v", err)
+	}
+	var trust *TrustError
+	err := notaryError("foo", "https://notary", signed.ErrRoleThreshold{})
+	if !errors.As(err, &trust) || trust.Collection != "foo" {
+		t.Errorf("Expected TrustError: %v", err)
+	}
+}
+
+func TestExecError(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	_, err := Run("sh", "-c", "printf 'bad things'; exit 3")
+	var execErr *ExecError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("Expected ExecError: %v", err)
+	}
+	if execErr.Output != "bad things" {
+		t.Errorf("Unexpected output: %s", execErr.Output)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("Expected exit code 3: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// How long before a role's metadata expires warnings start by default
+const DefaultExpiryWarning = 7 * 24 * time.Hour
+
+// RoleExpiry describes when the metadata of a role cached in the trust dir
+// expires. Updates fail once any role needed to validate a collection has
+// expired and the server doesn't provide newer metadata.
+type RoleExpiry struct {
+	Collection string    `json:"collection"`
+	Role       string    `json:"role"`
+	Version    int       `json:"version"`
+	Expires    time.Time `json:"expires"`
+	// Set when the role expires within the warning window
+	Expiring bool `json:"expiring"`
+	Expired  bool `json:"expired"`
+}
+
+func (r RoleExpiry) String() string {
+	return fmt.Sprintf("%s %s version %d expires %s", r.Collection, r.Role, r.Version, r.Expires.Format(time.RFC3339))
+}
+
+// Returns how long before metadata expires the device warns about it
+func (d *Device) ExpiryWarning() (time.Duration, error) {
+	if len(d.Config.MetadataExpiryWarning) == 0 {
+		return DefaultExpiryWarning, nil
+	}
+	window, err := time.ParseDuration(d.Config.MetadataExpiryWarning)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid MetadataExpiryWarning: %s", err)
+	}
+	return window, nil
+}
+
+// MetadataExpiry returns the expiry of every role cached for the base and
+// personality collections, soonest first. Roles expiring before now+window
+// are flagged as expiring.
+func (d *Device) MetadataExpiry(window time.Duration) ([]RoleExpiry, error) {
+	var expiries []RoleExpiry
+	if d.BaseNotary != nil {
+		roles, err := d.BaseNotary.CachedExpiry(d.Config.BaseCollectionName)
+		if err != nil {
+			return nil, err
+		}
+		expiries = append(expiries, roles...)
+	}
+	for _, p := range d.Personalities {
+		roles, err := p.Notary.CachedExpiry(p.Config.CollectionName)
+		if err != nil {
+			return nil, err
+		}
+		expiries = append(expiries, roles...)
+	}
+
+	now := time.Now()
+	for idx := range expiries {
+		expiries[idx].Expired = now.After(expiries[idx].Expires)
+		expiries[idx].Expiring = now.Add(window).After(expiries[idx].Expires)
+	}
+	sort.SliceStable(expiries, func(i, j int) bool {
+		return expiries[i].Expires.Before(expiries[j].Expires)
+	})
+	return expiries, nil
+}
+
+// CachedExpiry reads the expiry of each role of a collection from the
+// metadata cached in the trust dir, including delegations. Nothing is
+// returned for a collection that was never fetched.
+func (c NotaryClient) CachedExpiry(image string) ([]RoleExpiry, error) {
+	metaDir := filepath.Join(c.trustDir, "tuf", filepath.FromSlash(image), "metadata")
+	var expiries []RoleExpiry
+	err := filepath.Walk(metaDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == metaDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		meta := struct {
+			Signed data.SignedCommon `json:"signed"`
+		}{}
+		if err := json.Unmarshal(buf, &meta); err != nil {
+			return fmt.Errorf("Unable to parse cached TUF metadata %s: %s", path, err)
+		}
+		role, _ := filepath.Rel(metaDir, strings.TrimSuffix(path, ".json"))
+		expiries = append(expiries, RoleExpiry{
+			Collection: image,
+			Role:       filepath.ToSlash(role),
+			Version:    meta.Signed.Version,
+			Expires:    meta.Signed.Expires,
+		})
+		return nil
+	})
+	return expiries, err
+}
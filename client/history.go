@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+const (
+	HistoryBase        = "base"
+	HistoryPersonality = "personality"
+
+	HistorySuccess = "success"
+	HistoryFailure = "failure"
+
+	DefaultHistoryMaxSize = 1024 * 1024
+)
+
+type HistoryEntry struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Personality string    `json:"personality,omitempty"`
+	FromTarget  string    `json:"fromTarget,omitempty"`
+	FromHash    string    `json:"fromHash,omitempty"`
+	ToTarget    string    `json:"toTarget"`
+	ToHash      string    `json:"toHash"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"durationMs"`
+	Trigger     string    `json:"trigger,omitempty"`
+	// The target that was installed so it can be restored later
+	Target *client.TargetWithRole `json:"target,omitempty"`
+}
+
+// History is an append-only log of update attempts stored as one JSON
+// document per line. Once the file grows past maxSize the oldest entries
+// are dropped.
+type History struct {
+	file    string
+	maxSize int64
+}
+
+func (d *Device) History() *History {
+	maxSize := d.Config.HistoryMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultHistoryMaxSize
+	}
+	return &History{path.Join(d.configDir, "history.json"), maxSize}
+}
+
+func (h *History) Append(entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal history entry: %s", err)
+	}
+	fd, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("Unable to open history: %s", err)
+	}
+	_, err = fd.Write(append(data, '\n'))
+	fd.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to write history: %s", err)
+	}
+	return h.truncate()
+}
+
+// Drops the oldest entries once the file is too big. A quarter of the
+// allowed size is freed so this doesn't happen on every append.
+func (h *History) truncate() error {
+	st, err := os.Stat(h.file)
+	if err != nil || st.Size() <= h.maxSize {
+		return err
+	}
+	buf, err := ioutil.ReadFile(h.file)
+	if err != nil {
+		return fmt.Errorf("Unable to read history: %s", err)
+	}
+	for int64(len(buf)) > h.maxSize*3/4 {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx < 0 {
+			buf = nil
+			break
+		}
+		buf = buf[idx+1:]
+	}
+	tmp := h.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0640); err != nil {
+		return fmt.Errorf("Unable to write history: %s", err)
+	}
+	return os.Rename(tmp, h.file)
+}
+
+// Entries returns the history, oldest first
+func (h *History) Entries() ([]HistoryEntry, error) {
+	fd, err := os.Open(h.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to open history: %s", err)
+	}
+	defer fd.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, int(h.maxSize)+bufio.MaxScanTokenSize)
+	for scanner.Scan() {
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logrus.Warnf("Skipping invalid history entry: %s", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read history: %s", err)
+	}
+	return entries, nil
+}
+
+func newHistoryEntry(kind string, from, to *client.TargetWithRole, trigger string) HistoryEntry {
+	entry := HistoryEntry{
+		Time:     time.Now().UTC(),
+		Kind:     kind,
+		ToTarget: to.Name,
+		ToHash:   hex.EncodeToString(to.Hashes["sha256"]),
+		Trigger:  trigger,
+		Target:   to,
+	}
+	if from != nil {
+		entry.FromTarget = from.Name
+		entry.FromHash = hex.EncodeToString(from.Hashes["sha256"])
+	}
+	return entry
+}
+
+// Completes an entry with the outcome of the update and saves it. Failing
+// to record history is logged rather than failing the update.
+func (h *History) record(entry HistoryEntry, err error) {
+	entry.DurationMs = int64(time.Since(entry.Time) / time.Millisecond)
+	entry.Result = HistorySuccess
+	if err != nil {
+		entry.Result = HistoryFailure
+		entry.Error = err.Error()
+	}
+	if err := h.Append(entry); err != nil {
+		logrus.Warnf("Unable to record update history: %s", err)
+	}
+}
+
+// Returns the most recent successful update of the given kind (and
+// personality) that installed hash
+func (h *History) lastInstall(kind, personality, hash string) (*HistoryEntry, error) {
+	entries, err := h.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		e := entries[idx]
+		if e.Kind == kind && e.Personality == personality && e.ToHash == hash && e.Result == HistorySuccess {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
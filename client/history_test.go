@@ -0,0 +1,77 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := Device{configDir: dir}
+	h := d.History()
+	entries, err := h.Entries()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Empty history expected: %v %s", entries, err)
+	}
+
+	from := baseTestTarget("v1-intel", "aa", "")
+	to := baseTestTarget("v2-intel", "bb", "")
+	h.record(newHistoryEntry(HistoryBase, from, to, "manual"), nil)
+	h.record(newHistoryEntry(HistoryBase, to, from, "cron"), fmt.Errorf("pull failed"))
+
+	entries, err = h.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, found %d", len(entries))
+	}
+	if entries[0].FromTarget != "v1-intel" || entries[0].ToHash != "bb" || entries[0].Result != HistorySuccess {
+		t.Errorf("Invalid history entry: %v", entries[0])
+	}
+	if entries[1].Result != HistoryFailure || entries[1].Error != "pull failed" || entries[1].Trigger != "cron" {
+		t.Errorf("Invalid history entry: %v", entries[1])
+	}
+	if entries[1].Target == nil || entries[1].Target.Name != "v1-intel" {
+		t.Errorf("History should include the target: %v", entries[1].Target)
+	}
+}
+
+func TestHistoryTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := History{path.Join(dir, "history.json"), 4096}
+	to := baseTestTarget("v2-intel", "bb", "")
+	for i := 0; i < 50; i++ {
+		entry := newHistoryEntry(HistoryBase, nil, to, fmt.Sprintf("run-%d", i))
+		if err := h.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := os.Stat(h.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() > h.maxSize {
+		t.Errorf("History not truncated: %d > %d", st.Size(), h.maxSize)
+	}
+	entries, err := h.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[len(entries)-1].Trigger != "run-49" {
+		t.Errorf("Newest entries should be kept: %v", entries)
+	}
+}
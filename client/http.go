@@ -0,0 +1,299 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/docker/distribution/registry/client/transport"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+)
+
+// Per host credentials in the config dir
+const credentialsFile = "credentials.json"
+
+// HostCredentials authenticate the requests to a host. credentials.json maps
+// hosts, as in URLs, e.g. "files.example.com" or "files.example.com:8443", to
+// them.
+type HostCredentials struct {
+	// Sent as a bearer token
+	Token string `json:",omitempty"`
+	// Sent as basic auth, or used to request tokens from notary servers and
+	// registries
+	Username string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	// Verifies the host instead of the server's CA file or the system's CAs
+	CAFile string `json:",omitempty"`
+}
+
+// httpClients creates the HTTP clients and transports for notary servers,
+// registries and tarball hosts. All of them present the device's TLS
+// identity, honor the proxy environment, identify as tuftree and
+// authenticate with the host's credentials.
+type httpClients struct {
+	tls tlsFiles
+	// The host tls.caFile verifies, e.g. the notary server. Other hosts use
+	// their CAFile in credentials.json or the system's CAs. Any host when
+	// empty.
+	caHost string
+	// Read whenever a transport is created, so credentials can be rotated
+	// without reconfiguring the device
+	credentialsFile string
+}
+
+// Returns the HTTP clients for a server whose CA is caFile
+func (c DeviceConfig) httpClients(configDir, serverURL, caFile string) httpClients {
+	return httpClients{c.tlsFiles(caFile), hostOf(serverURL), path.Join(configDir, credentialsFile)}
+}
+
+// Returns the credentials configured for a host, none when it or the
+// credentials file doesn't exist
+func (h httpClients) hostCredentials(host string) (HostCredentials, error) {
+	if len(h.credentialsFile) == 0 {
+		return HostCredentials{}, nil
+	}
+	buf, err := ioutil.ReadFile(h.credentialsFile)
+	if os.IsNotExist(err) {
+		return HostCredentials{}, nil
+	} else if err != nil {
+		return HostCredentials{}, fmt.Errorf("Unable to read credentials: %s", err)
+	}
+	hosts := make(map[string]HostCredentials)
+	if err := json.Unmarshal(buf, &hosts); err != nil {
+		return HostCredentials{}, fmt.Errorf("Error in %s: %s", h.credentialsFile, err)
+	}
+	return hosts[host], nil
+}
+
+// Returns the host of a URL, empty when it can't be parsed
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// Returns the TLS transport for a host along with its credentials
+func (h httpClients) transport(host string) (*http.Transport, HostCredentials, error) {
+	creds, err := h.hostCredentials(host)
+	if err != nil {
+		return nil, creds, err
+	}
+	files := h.tls
+	if len(creds.CAFile) > 0 {
+		files.caFile = creds.CAFile
+	} else if len(h.caHost) > 0 && host != h.caHost {
+		files.caFile = ""
+	}
+	base, err := baseTransport(files)
+	return base, creds, err
+}
+
+// hostTransport verifies each request with the TLS config of its host. A
+// client follows redirects with the same transport, and e.g. the public
+// storage a private registry redirects to isn't signed by its CA.
+type hostTransport struct {
+	h          httpClients
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func newHostTransport(h httpClients) *hostTransport {
+	return &hostTransport{h: h, transports: make(map[string]*http.Transport)}
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	base, ok := t.transports[req.URL.Host]
+	if !ok {
+		var err error
+		if base, _, err = t.h.transport(req.URL.Host); err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		t.transports[req.URL.Host] = base
+	}
+	t.mu.Unlock()
+	return base.RoundTrip(req)
+}
+
+// Returns the client for plain requests, like tarball downloads, to the host
+// of rawurl
+func (h httpClients) client(rawurl string) (*http.Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL %s: %s", rawurl, err)
+	}
+	creds, err := h.hostCredentials(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	modifiers := []transport.RequestModifier{userAgent}
+	if m := creds.modifier(u.Host); m != nil {
+		modifiers = append(modifiers, m)
+	}
+	return &http.Client{Transport: transport.NewTransport(newHostTransport(h), modifiers...)}, nil
+}
+
+// Returns the modifier adding the credentials to requests to their host,
+// nil when there are none
+func (c HostCredentials) modifier(host string) transport.RequestModifier {
+	var m transport.RequestModifier
+	if len(c.Token) > 0 {
+		m = transport.NewHeaderRequestModifier(http.Header{
+			"Authorization": []string{"Bearer " + c.Token},
+		})
+	} else if len(c.Username) > 0 {
+		m = basicAuth{c.Username, c.Password}
+	} else {
+		return nil
+	}
+	return hostModifier{host, m}
+}
+
+// Modifiers run for every request of a client, including those following
+// redirects. This keeps credentials from reaching other hosts, like the
+// storage a registry redirects blob downloads to.
+type hostModifier struct {
+	host string
+	m    transport.RequestModifier
+}
+
+func (h hostModifier) ModifyRequest(req *http.Request) error {
+	if req.URL.Host != h.host {
+		return nil
+	}
+	return h.m.ModifyRequest(req)
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (b basicAuth) ModifyRequest(req *http.Request) error {
+	req.SetBasicAuth(b.username, b.password)
+	return nil
+}
+
+// Supplies the configured credentials to the auth handlers of notary servers
+// and registries. Their token servers may be on other hosts.
+type credentialStore struct {
+	h httpClients
+}
+
+func (s credentialStore) Basic(u *url.URL) (string, string) {
+	creds, err := s.h.hostCredentials(u.Host)
+	if err != nil {
+		logrus.Warn(err)
+	}
+	return creds.Username, creds.Password
+}
+
+func (credentialStore) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (credentialStore) SetRefreshToken(*url.URL, string, string) {
+}
+
+// Binds the requests of clients that don't accept a context, like notary's,
+// to one
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// registryTransport creates a transport able to perform "pull" operations
+// on a repository of a notary server or docker registry. Both use the same
+// token based authentication scheme, unless a token is configured for the
+// server. All requests made with it, including token requests, are bound to
+// ctx.
+func (h httpClients) registryTransport(ctx context.Context, serverURL, repository string) (http.RoundTripper, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL %s: %s", serverURL, err)
+	}
+	creds, err := h.hostCredentials(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	base := contextTransport{ctx, newHostTransport(h)}
+	if len(creds.Token) > 0 {
+		return transport.NewTransport(base, userAgent, creds.modifier(u.Host)), nil
+	}
+	resp, err := ping(ctx, base, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	challengeManager := challenge.NewSimpleManager()
+	if err := challengeManager.AddResponse(resp); err != nil {
+		return nil, &NetworkError{Url: serverURL, Err: fmt.Errorf("Unable to process auth challenge: %s", err)}
+	}
+	store := credentialStore{h}
+	tokenHandler := auth.NewTokenHandler(base, store, repository, "pull")
+	modifiers := []transport.RequestModifier{
+		userAgent,
+		auth.NewAuthorizer(challengeManager, tokenHandler, auth.NewBasicHandler(store)),
+	}
+	return transport.NewTransport(base, modifiers...), nil
+}
+
+var userAgent = transport.NewHeaderRequestModifier(http.Header{
+	"User-Agent": []string{"tuftree"},
+})
+
+func baseTransport(files tlsFiles) (*http.Transport, error) {
+	tlsConfig, err := tlsconfig.Client(files.options())
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure TLS: %s", err.Error())
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		DisableKeepAlives:   true,
+	}, nil
+}
+
+// Pings the /v2/ endpoint of a notary server or registry. The response
+// carries the authentication challenge. The caller must close its body.
+func ping(ctx context.Context, base http.RoundTripper, serverURL string) (*http.Response, error) {
+	pingClient := &http.Client{
+		Transport: transport.NewTransport(base, userAgent),
+		Timeout:   5 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pingClient.Do(req)
+	if err != nil {
+		return nil, &NetworkError{Url: serverURL, Err: err}
+	}
+	return resp, nil
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestDownloadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("personality")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer secret" && !(ok && user == "device" && pass == "pw") {
+			w.WriteHeader(401)
+			return
+		}
+		if r.Header.Get("User-Agent") != "tuftree" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+	caFile := path.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	host := server.Listener.Addr().String()
+	saveCredentials := func(creds string) {
+		if err := ioutil.WriteFile(path.Join(dir, credentialsFile), []byte(creds), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := DeviceConfig{}.httpClients(dir, "", "")
+	dst := path.Join(dir, "archive")
+	hash := sha256Hex(content)
+	// Self-signed, so the host's CA is needed
+	if err := downloadTo(context.Background(), h, dst, server.URL, hash); err == nil {
+		t.Fatal("Download from an untrusted host should fail")
+	}
+	saveCredentials(fmt.Sprintf(`{"%s": {"CAFile": "%s"}}`, host, caFile))
+	err = downloadTo(context.Background(), h, dst, server.URL, hash)
+	if netErr, ok := err.(*NetworkError); !ok || netErr.StatusCode != 401 {
+		t.Fatalf("Download without credentials should be refused: %v", err)
+	}
+	saveCredentials(fmt.Sprintf(`{"%s": {"CAFile": "%s", "Token": "secret"}}`, host, caFile))
+	if err := downloadTo(context.Background(), h, dst, server.URL, hash); err != nil {
+		t.Fatalf("Download with a token failed: %s", err)
+	}
+	saveCredentials(fmt.Sprintf(`{"%s": {"CAFile": "%s", "Username": "device", "Password": "pw"}}`, host, caFile))
+	if err := downloadTo(context.Background(), h, dst, server.URL, hash); err != nil {
+		t.Fatalf("Download with basic auth failed: %s", err)
+	}
+
+	u, _ := url.Parse(server.URL)
+	if user, pass := (credentialStore{h}).Basic(u); user != "device" || pass != "pw" {
+		t.Errorf("Unexpected token server credentials: %s %s", user, pass)
+	}
+	saveCredentials("not json")
+	if err := downloadTo(context.Background(), h, dst, server.URL, hash); err == nil {
+		t.Error("Invalid credentials file should fail")
+	}
+}
+
+func TestServerCAHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := path.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := DeviceConfig{}.httpClients(dir, "https://notary.example.com", caFile)
+	base, _, err := h.transport("notary.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.TLSClientConfig.RootCAs == nil {
+		t.Error("The notary CA should verify the notary server")
+	}
+	// e.g. tarballs or OCI artifacts on a public host
+	base, _, err = h.transport("registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.TLSClientConfig.RootCAs != nil {
+		t.Error("Other hosts should be verified with the system's CAs")
+	}
+}
+
+// Returns a self-signed certificate for 127.0.0.1
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "storage"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCredentialsNotRedirected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("personality")
+	var leaked []string
+	storage := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+			leaked = append(leaked, auth)
+		}
+		w.Write(content)
+	}))
+	// Its own certificate, so it can only be verified with its own CA
+	storage.TLS = &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}}
+	storage.StartTLS()
+	defer storage.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(401)
+			return
+		}
+		http.Redirect(w, r, storage.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	var hosts []string
+	for _, s := range []*httptest.Server{server, storage} {
+		caFile := path.Join(dir, s.Listener.Addr().String()+".crt")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+		if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, fmt.Sprintf(`"%s": {"CAFile": "%s"%%s}`, s.Listener.Addr().String(), caFile))
+	}
+	creds := fmt.Sprintf("{"+hosts[0]+", "+hosts[1]+"}", `, "Token": "secret"`, "")
+	if err := ioutil.WriteFile(path.Join(dir, credentialsFile), []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := DeviceConfig{}.httpClients(dir, "", "")
+	dst := path.Join(dir, "archive")
+	if err := downloadTo(context.Background(), h, dst, server.URL+"/archive.tgz", sha256Hex(content)); err != nil {
+		t.Fatalf("Redirected download failed: %s", err)
+	}
+
+	// Registries redirect blob downloads the same way
+	rt, err := h.registryTransport(context.Background(), server.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: rt}).Get(server.URL + "/v2/foo/blobs/sha256:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Redirected blob download failed: %d", resp.StatusCode)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("Credentials sent to the redirect target: %v", leaked)
+	}
+}
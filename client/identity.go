@@ -0,0 +1,126 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/sirupsen/logrus"
+)
+
+// Files created by GenerateKey in the config dir. The certificate is
+// expected next to them once the CSR has been signed.
+const (
+	deviceKeyFile  = "device.key"
+	deviceCSRFile  = "device.csr"
+	deviceCertFile = "device.crt"
+)
+
+// The PEM files used when connecting to a server
+type tlsFiles struct {
+	// Replaces the system's CAs when set
+	caFile string
+	// The device's identity for servers requiring mutual TLS
+	certFile string
+	keyFile  string
+}
+
+// Returns the TLS files for a server signed by caFile
+func (c DeviceConfig) tlsFiles(caFile string) tlsFiles {
+	return tlsFiles{caFile, c.TLSCertFile, c.TLSKeyFile}
+}
+
+// Returns the client certificate and key to present, empty when the device
+// has none. A configured certificate that doesn't exist yet, e.g. while its
+// CSR is waiting to be signed, is skipped.
+func (f tlsFiles) identity() (string, string) {
+	if len(f.certFile) == 0 || len(f.keyFile) == 0 {
+		return "", ""
+	}
+	if _, err := os.Stat(f.certFile); os.IsNotExist(err) {
+		logrus.Warnf("Client certificate %s not found, connecting without one", f.certFile)
+		return "", ""
+	}
+	return f.certFile, f.keyFile
+}
+
+func (f tlsFiles) options() tlsconfig.Options {
+	opts := tlsconfig.Options{
+		CAFile:             f.caFile,
+		ExclusiveRootPools: true,
+	}
+	opts.CertFile, opts.KeyFile = f.identity()
+	return opts
+}
+
+// GenerateDeviceKey creates a private key for a device that's about to be
+// initialized and a certificate signing request for it in the config dir.
+// The CSR's common name is the device ID, which is generated when config
+// has none. config is updated to use the key and the certificate, which
+// takes effect once the signed certificate is saved as device.crt. Nothing
+// is fetched, so it works before the device can reach servers requiring
+// mutual TLS. An existing key is never replaced. The path of the CSR is
+// returned.
+func GenerateDeviceKey(configDir string, config *DeviceConfig) (string, error) {
+	keyFile := path.Join(configDir, deviceKeyFile)
+	if _, err := os.Stat(keyFile); err == nil {
+		return "", fmt.Errorf("Device key %s already exists", keyFile)
+	}
+	if len(config.DeviceId) == 0 {
+		var err error
+		if config.DeviceId, err = newDeviceId(); err != nil {
+			return "", fmt.Errorf("Unable to generate a device ID: %s", err)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate device key: %s", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("Unable to encode device key: %s", err)
+	}
+	template := x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: config.DeviceId},
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create device CSR: %s", err)
+	}
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(keyFile, pemKey, 0600); err != nil {
+		return "", fmt.Errorf("Unable to save device key: %s", err)
+	}
+	csrFile := path.Join(configDir, deviceCSRFile)
+	pemCSR := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	if err := ioutil.WriteFile(csrFile, pemCSR, 0644); err != nil {
+		return "", fmt.Errorf("Unable to save device CSR: %s", err)
+	}
+	config.TLSKeyFile = keyFile
+	config.TLSCertFile = path.Join(configDir, deviceCertFile)
+	return csrFile, nil
+}
+
+// GenerateKey is GenerateDeviceKey for an initialized device. config.json
+// is updated.
+func (d *Device) GenerateKey() (string, error) {
+	newConfig := d.Config
+	csrFile, err := GenerateDeviceKey(d.configDir, &newConfig)
+	if err != nil {
+		return "", err
+	}
+	if err := saveConfig(path.Join(d.configDir, "config.json"), newConfig); err != nil {
+		return "", err
+	}
+	d.Config = newConfig
+	return csrFile, nil
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Signs the device's CSR with itself, standing in for a device CA
+func signDeviceCSR(t *testing.T, dir string) {
+	t.Helper()
+	block, _ := pem.Decode(readFile(t, path.Join(dir, deviceCSRFile)))
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode(readFile(t, path.Join(dir, deviceKeyFile)))
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, csr.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(path.Join(dir, deviceCertFile), cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestGenerateKey(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "identity-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := Device{configDir: dir, Config: DeviceConfig{DeviceId: "abc123"}}
+
+	csrFile, err := d.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(readFile(t, csrFile))
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("Invalid CSR: %s", err)
+	}
+	if csr.Subject.CommonName != "abc123" {
+		t.Errorf("CSR should be for the device ID: %s", csr.Subject.CommonName)
+	}
+	if st, err := os.Stat(d.Config.TLSKeyFile); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("Key must only be readable by its owner: %v", err)
+	}
+	if _, err := d.GenerateKey(); err == nil {
+		t.Error("An existing key must not be replaced")
+	}
+
+	// Unsigned so far, so no identity is presented
+	files := d.Config.tlsFiles("")
+	if cert, key := files.identity(); len(cert) > 0 || len(key) > 0 {
+		t.Errorf("Missing certificate should be skipped: %s %s", cert, key)
+	}
+	signDeviceCSR(t, dir)
+	if cert, key := files.identity(); cert != path.Join(dir, deviceCertFile) || key != path.Join(dir, deviceKeyFile) {
+		t.Errorf("Unexpected identity: %s %s", cert, key)
+	}
+
+	cert, key := files.identity()
+	if err := OSTreeAddRemote(dir, "tuftree", "https://example.com", true, cert, key); err != nil {
+		t.Fatal(err)
+	}
+	conf := string(readFile(t, path.Join(dir, "tuftree.conf")))
+	if !strings.Contains(conf, "tls-client-cert-path="+cert+"\n") || !strings.Contains(conf, "tls-client-key-path="+key+"\n") {
+		t.Errorf("Remote missing client certificate:\n%s", conf)
+	}
+}
+
+func TestDownloadMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identity-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := Device{configDir: dir, Config: DeviceConfig{DeviceId: "abc123"}}
+	if _, err := d.GenerateKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("personality")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "abc123" {
+			w.WriteHeader(403)
+			return
+		}
+		w.Write(content)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	caFile := path.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := d.Config.tlsFiles(caFile)
+	dst := path.Join(dir, "archive")
+	hash := sha256Hex(content)
+	err = downloadTo(context.Background(), httpClients{tls: files}, dst, server.URL, hash)
+	if netErr, ok := err.(*NetworkError); !ok || netErr.StatusCode != 403 {
+		t.Fatalf("Download without a certificate should be refused: %v", err)
+	}
+	signDeviceCSR(t, dir)
+	if err := downloadTo(context.Background(), httpClients{tls: files}, dst, server.URL, hash); err != nil {
+		t.Fatalf("Download with a certificate failed: %s", err)
+	}
+}
+
+func TestInitializeMutualTLS(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	requests := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	// Refuses devices without a certificate during the handshake
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	caFile := path.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DeviceConfig{
+		BaseNotaryServerUrl: server.URL,
+		BaseNotaryCAFile:    caFile,
+		BaseCollectionName:  "hub.foundries.io/lmp",
+	}
+	csrFile, err := GenerateDeviceKey(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(readFile(t, csrFile))
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil || len(config.DeviceId) == 0 || csr.Subject.CommonName != config.DeviceId {
+		t.Fatalf("CSR should be for the generated device ID %s: %v", config.DeviceId, err)
+	}
+
+	// The CSR isn't signed yet, so the server can't be probed for it
+	runner := newFakeRunner().on("ostree admin status", "* lmp aa.0\n", nil)
+	config.HardwareId = "intel"
+	d, err := DeviceInitializeWithRunner(dir, config, runner)
+	if err != nil {
+		t.Fatalf("Initializing with a hardware ID should not need the server: %s", err)
+	}
+	if d.Config.TLSCertFile != path.Join(dir, deviceCertFile) || d.Config.DeviceId != config.DeviceId {
+		t.Errorf("Device key not configured: %v", d.Config)
+	}
+	if requests != 0 {
+		t.Errorf("The server should not have been reached: %d", requests)
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+)
+
+// How often a waiting Lock retries
+var lockPollInterval = 500 * time.Millisecond
+
+// LockHolder identifies the process holding a configuration directory's lock
+type LockHolder struct {
+	Pid     int       `json:"pid"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+// Reports whether the holder's process still exists
+func (h *LockHolder) Running() bool {
+	err := syscall.Kill(h.Pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Lock is an advisory lock on a configuration directory. The kernel drops
+// it when the process exits, so a crashed run can't leave the directory
+// locked.
+type Lock struct {
+	fd *os.File
+	// Set when the previous holder exited without calling Unlock
+	Previous *LockHolder
+}
+
+func readLockHolder(fd *os.File) *LockHolder {
+	buf, err := ioutil.ReadAll(fd)
+	if err != nil || len(buf) == 0 {
+		return nil
+	}
+	holder := LockHolder{}
+	if err := json.Unmarshal(buf, &holder); err != nil {
+		logrus.Debugf("Ignoring invalid lock file content: %s", err)
+		return nil
+	}
+	return &holder
+}
+
+// LockConfigDir takes the lock of a configuration directory for command.
+// When the lock is held by another process a *LockedError is returned, or
+// with wait, it's retried until ctx is done.
+func LockConfigDir(ctx context.Context, configDir, command string, wait bool) (*Lock, error) {
+	fd, err := os.OpenFile(path.Join(configDir, "tuftree.lock"), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	logged := false
+	for {
+		err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			fd.Close()
+			return nil, err
+		}
+		fd.Seek(0, 0)
+		lockedErr := &LockedError{readLockHolder(fd)}
+		if !wait {
+			fd.Close()
+			return nil, lockedErr
+		}
+		if !logged {
+			logrus.Infof("%s, waiting", lockedErr)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			fd.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	lock := Lock{fd: fd}
+	if lock.Previous = readLockHolder(fd); lock.Previous != nil {
+		logrus.Warnf("Previous run, pid %d (%s), did not release its lock. It may have crashed part way through",
+			lock.Previous.Pid, lock.Previous.Command)
+	}
+	holder, err := json.Marshal(LockHolder{os.Getpid(), command, time.Now().UTC()})
+	if err == nil {
+		err = fd.Truncate(0)
+	}
+	if err == nil {
+		_, err = fd.WriteAt(holder, 0)
+	}
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// Unlock clears the holder information and releases the lock
+func (l *Lock) Unlock() error {
+	if err := l.fd.Truncate(0); err != nil {
+		logrus.Warnf("Unable to clear lock file: %s", err)
+	}
+	return l.fd.Close()
+}
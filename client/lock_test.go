@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestLockConfigDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := LockConfigDir(context.Background(), dir, "update", false)
+	if err != nil {
+		t.Fatalf("Unable to lock: %s", err)
+	}
+	if lock.Previous != nil {
+		t.Errorf("Unexpected previous holder: %v", lock.Previous)
+	}
+
+	_, err = LockConfigDir(context.Background(), dir, "cleanup", false)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected LockedError: %v", err)
+	}
+	if locked.Holder == nil || locked.Holder.Pid != os.Getpid() || locked.Holder.Command != "update" {
+		t.Errorf("Invalid lock holder: %v", locked.Holder)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = LockConfigDir(ctx, dir, "cleanup", true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Waiting should stop with the context: %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = LockConfigDir(context.Background(), dir, "cleanup", false)
+	if err != nil {
+		t.Fatalf("Unable to lock after unlock: %s", err)
+	}
+	if lock.Previous != nil {
+		t.Errorf("A released lock has no previous holder: %v", lock.Previous)
+	}
+	lock.fd.Close()
+}
+
+func TestLockConfigDirStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Left behind by a run that crashed
+	stale := `{"pid": 999999999, "command": "update", "since": "2020-01-01T00:00:00Z"}`
+	if err := ioutil.WriteFile(path.Join(dir, "tuftree.lock"), []byte(stale), 0640); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := LockConfigDir(context.Background(), dir, "update", false)
+	if err != nil {
+		t.Fatalf("A stale lock file shouldn't block: %s", err)
+	}
+	defer lock.Unlock()
+	if lock.Previous == nil || lock.Previous.Pid != 999999999 || lock.Previous.Running() {
+		t.Errorf("Stale holder not reported: %v", lock.Previous)
+	}
+}
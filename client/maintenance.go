@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DownloadAnytime  = "anytime"
+	DownloadInWindow = "window"
+)
+
+type maintenanceWindow struct {
+	// Any day when empty
+	days  map[time.Weekday]bool
+	start time.Duration
+	end   time.Duration
+}
+
+// MaintenanceSchedule tells when automatic updates may change the device
+type MaintenanceSchedule struct {
+	windows  []maintenanceWindow
+	location *time.Location
+	// Set when downloads are also limited to the windows
+	DownloadInWindow bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Parses "HH:MM" into the time since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Returns the device's maintenance schedule
+func (d *Device) MaintenanceSchedule() (*MaintenanceSchedule, error) {
+	cfg := d.Config.Maintenance
+	s := MaintenanceSchedule{location: time.Local}
+	if len(cfg.Timezone) > 0 {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Maintenance.Timezone: %s", err)
+		}
+		s.location = loc
+	}
+	switch cfg.Download {
+	case "", DownloadAnytime:
+	case DownloadInWindow:
+		s.DownloadInWindow = true
+	default:
+		return nil, fmt.Errorf("Invalid Maintenance.Download '%s', must be %s or %s", cfg.Download, DownloadAnytime, DownloadInWindow)
+	}
+
+	for idx, w := range cfg.Windows {
+		window := maintenanceWindow{days: make(map[time.Weekday]bool)}
+		for _, day := range w.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok && len(day) > 3 {
+				weekday, ok = weekdays[strings.ToLower(day[:3])]
+			}
+			if !ok {
+				return nil, fmt.Errorf("Invalid Maintenance.Windows[%d] day '%s'", idx, day)
+			}
+			window.days[weekday] = true
+		}
+		var err error
+		if window.start, err = parseTimeOfDay(w.Start); err != nil {
+			return nil, fmt.Errorf("Invalid Maintenance.Windows[%d] start: %s", idx, err)
+		}
+		if window.end, err = parseTimeOfDay(w.End); err != nil {
+			return nil, fmt.Errorf("Invalid Maintenance.Windows[%d] end: %s", idx, err)
+		}
+		s.windows = append(s.windows, window)
+	}
+	return &s, nil
+}
+
+func (w maintenanceWindow) opensOn(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+func (w maintenanceWindow) open(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	now := t.Sub(midnight)
+	if w.start <= w.end {
+		return now >= w.start && now < w.end && w.opensOn(t.Weekday())
+	}
+	// Past midnight the window belongs to the day it opened
+	if now >= w.start {
+		return w.opensOn(t.Weekday())
+	}
+	return now < w.end && w.opensOn(midnight.Add(-time.Hour).Weekday())
+}
+
+// Open reports whether automatic updates may install at t. They always may
+// without windows.
+func (s *MaintenanceSchedule) Open(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	t = t.In(s.location)
+	for _, w := range s.windows {
+		if w.open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a step installs a target flagged as urgent
+func (step PlannedUpdate) urgent() bool {
+	custom, err := tufCustom(step.Target)
+	return err == nil && custom.Urgent
+}
+
+// Splits a plan into the urgent steps, which install right away, and the
+// steps to stage until a window opens. An urgent base takes along the
+// personality updates the installed personalities need to run on it.
+func urgentSteps(plan *UpdatePlan) (*UpdatePlan, []PlannedUpdate, error) {
+	var base *baseVersion
+	for _, step := range plan.Steps {
+		if step.Personality == nil && step.urgent() {
+			var err error
+			if base, err = newBaseVersion(step.Target); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	urgent := &UpdatePlan{Trigger: plan.Trigger}
+	if base != nil {
+		urgent.Deferred = plan.Deferred
+	}
+	var staged []PlannedUpdate
+	for _, step := range plan.Steps {
+		if step.urgent() {
+			urgent.Steps = append(urgent.Steps, step)
+		} else if step.Personality != nil && base != nil && checkCompatible(base, step.Personality.current()) != nil {
+			logrus.Infof("Installing personality(%s) %s along with the urgent base", step.Personality.Name(), step.Target.Name)
+			urgent.Steps = append(urgent.Steps, step)
+		} else {
+			staged = append(staged, step)
+		}
+	}
+	return urgent, staged, nil
+}
+
+// ApplyPlanAutomaticContext applies a plan for an automatic update. Within a
+// maintenance window it's applied like ApplyPlanContext would. Otherwise
+// only its urgent targets install, and the others are downloaded, unless
+// downloads are limited to the windows too, so that they install quickly
+// once a window opens.
+func (d *Device) ApplyPlanAutomaticContext(ctx context.Context, plan *UpdatePlan, now time.Time) error {
+	schedule, err := d.MaintenanceSchedule()
+	if err != nil {
+		return err
+	}
+	if schedule.Open(now) {
+		return d.ApplyPlanContext(ctx, plan)
+	}
+	urgent, staged, err := urgentSteps(plan)
+	if err != nil {
+		return err
+	}
+	if len(urgent.Steps) > 0 {
+		for _, step := range urgent.Steps {
+			logrus.Warnf("Installing update %s outside the maintenance window", step.Target.Name)
+		}
+		if err := d.ApplyPlanContext(ctx, urgent); err != nil {
+			return err
+		}
+	}
+	if len(staged) == 0 {
+		return nil
+	}
+	if schedule.DownloadInWindow {
+		logrus.Info("Outside the maintenance window, non-urgent updates will not be downloaded or installed")
+		return nil
+	}
+	logrus.Info("Outside the maintenance window, non-urgent updates will only be downloaded")
+	for _, step := range staged {
+		if step.Personality == nil {
+			err = d.StageBaseContext(ctx, step.Target)
+		} else {
+			err = step.Personality.StageContext(ctx, step.Target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/notary/client"
+)
+
+func TestMaintenanceSchedule(t *testing.T) {
+	d := Device{Config: DeviceConfig{Maintenance: MaintenanceConfig{
+		Timezone: "UTC",
+		Windows: []MaintenanceWindow{
+			{Days: []string{"Sat", "sunday"}, Start: "08:00", End: "12:00"},
+			{Days: []string{"Mon"}, Start: "22:00", End: "02:00"},
+		},
+	}}}
+	s, err := d.MaintenanceSchedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		time string
+		open bool
+	}{
+		{"2021-03-06T09:00:00Z", true},  // Saturday
+		{"2021-03-06T12:00:00Z", false}, // Saturday, closed
+		{"2021-03-07T08:00:00Z", true},  // Sunday
+		{"2021-03-08T09:00:00Z", false}, // Monday morning
+		{"2021-03-08T23:00:00Z", true},  // Monday night
+		{"2021-03-09T01:00:00Z", true},  // Still Monday's window
+		{"2021-03-10T01:00:00Z", false}, // Tuesday's doesn't exist
+		// 09:00 in Berlin is 08:00 UTC
+		{"2021-03-06T09:00:00+01:00", true},
+	}
+	for _, test := range tests {
+		now, _ := time.Parse(time.RFC3339, test.time)
+		if s.Open(now) != test.open {
+			t.Errorf("Open(%s) != %v", test.time, test.open)
+		}
+	}
+
+	if s, err := (&Device{}).MaintenanceSchedule(); err != nil || !s.Open(time.Now()) || s.DownloadInWindow {
+		t.Errorf("Updates should be allowed anytime by default: %v", err)
+	}
+	invalid := []MaintenanceConfig{
+		{Timezone: "Nowhere/Special"},
+		{Download: "never"},
+		{Windows: []MaintenanceWindow{{Days: []string{"Someday"}, Start: "01:00", End: "02:00"}}},
+		{Windows: []MaintenanceWindow{{Start: "1am", End: "02:00"}}},
+	}
+	for _, cfg := range invalid {
+		d := Device{Config: DeviceConfig{Maintenance: cfg}}
+		if _, err := d.MaintenanceSchedule(); err == nil {
+			t.Errorf("Invalid configuration should fail: %+v", cfg)
+		}
+	}
+}
+
+func TestApplyPlanAutomatic(t *testing.T) {
+	t.Parallel()
+	d, _ := newPlanDevice(t, t.TempDir())
+	d.OSTreeRemotesDir = t.TempDir()
+	d.BaseNotary = &NotaryClient{}
+	d.HardwareId = "intel"
+	d.Config.Retention.DisableAutoCleanup = true
+	d.Config.Maintenance.Windows = []MaintenanceWindow{{Start: "01:00", End: "02:00"}}
+	outside := time.Date(2021, 3, 6, 12, 0, 0, 0, time.Local)
+
+	// Outside the window the commit is only pulled, and referenced so that
+	// pruning keeps it
+	runner := newFakeRunner().
+		on("ostree pull tuftree bb", "", nil).
+		on("ostree refs --force --create=tuftree/staged bb", "", nil)
+	d.setRunner(runner)
+	plan := &UpdatePlan{Steps: []PlannedUpdate{{Target: baseTestTarget("v2-intel", "bb", "")}}}
+	if err := d.ApplyPlanAutomaticContext(context.Background(), plan, outside); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner, "ostree show bb", "ostree pull tuftree bb", "ostree refs --force --create=tuftree/staged bb")
+	if tgt, _, _ := d.BaseTarget(); tgt.Name != "v1-intel" {
+		t.Errorf("base.json should not change when staging: %s", tgt.Name)
+	}
+
+	// Nothing at all when downloads wait for the window too
+	d.Config.Maintenance.Download = DownloadInWindow
+	runner = newFakeRunner()
+	d.setRunner(runner)
+	if err := d.ApplyPlanAutomaticContext(context.Background(), plan, outside); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner)
+
+	// Urgent targets install anyway, as they do within the window
+	urgent := newTestTarget("v2-intel", "bb", `{"targetFormat": "OSTREE", "ostree": "http://example.com", "urgent": true}`)
+	for _, test := range []struct {
+		target *client.TargetWithRole
+		now    time.Time
+	}{
+		{urgent, outside},
+		{plan.Steps[0].Target, time.Date(2021, 3, 6, 1, 30, 0, 0, time.Local)},
+	} {
+		if err := os.RemoveAll(d.pendingBaseFile()); err != nil {
+			t.Fatal(err)
+		}
+		runner = newFakeRunner().
+			on("ostree show bb", "", nil).
+			on("ostree admin deploy bb", "", nil)
+		d.setRunner(runner)
+		plan := &UpdatePlan{Steps: []PlannedUpdate{{Target: test.target}}}
+		if err := d.ApplyPlanAutomaticContext(context.Background(), plan, test.now); err != nil {
+			t.Fatal(err)
+		}
+		assertCalls(t, runner, "ostree show bb", "ostree admin deploy bb")
+	}
+}
+
+func TestApplyPlanAutomaticUrgent(t *testing.T) {
+	t.Parallel()
+	d, p := newPlanDevice(t, t.TempDir())
+	d.OSTreeRemotesDir = t.TempDir()
+	d.BaseNotary = &NotaryClient{}
+	d.HardwareId = "intel"
+	d.Config.Retention.DisableAutoCleanup = true
+	d.Config.Maintenance.Windows = []MaintenanceWindow{{Start: "01:00", End: "02:00"}}
+	outside := time.Date(2021, 3, 6, 12, 0, 0, 0, time.Local)
+
+	custom := `{"targetFormat": "DOCKER_COMPOSE", "tgz": "http://example.com"%s}`
+	oldHash := cacheTestArchive(t, p, "version: '3'\nservices:\n  web:\n    image: web:1\n")
+	if err := saveTarget(p.StateFile(), newTestTarget("v1", oldHash, fmt.Sprintf(custom, ""))); err != nil {
+		t.Fatal(err)
+	}
+	hash := cacheTestArchive(t, p, "version: '3'\nservices:\n  web:\n    image: hub.foundries.io/web:2\n")
+
+	// Only the urgent personality installs, the base waits for the window
+	runner := newFakeRunner().
+		on("docker pull hub.foundries.io/web:2", "", nil).
+		on("docker-compose -f docker-compose.yml stop", "", nil).
+		on("docker-compose -f docker-compose.yml up -d", "", nil).
+		on("ostree pull tuftree bb", "", nil).
+		on("ostree refs --force --create=tuftree/staged bb", "", nil)
+	d.setRunner(runner)
+	plan := &UpdatePlan{Steps: []PlannedUpdate{
+		{Personality: p, Target: newTestTarget("v2", hash, fmt.Sprintf(custom, `, "urgent": true`))},
+		{Target: baseTestTarget("v2-intel", "bb", "")},
+	}}
+	if err := d.ApplyPlanAutomaticContext(context.Background(), plan, outside); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner,
+		"docker pull hub.foundries.io/web:2",
+		"docker-compose -f docker-compose.yml stop",
+		"docker-compose -f docker-compose.yml up -d",
+		"ostree show bb",
+		"ostree pull tuftree bb",
+		"ostree refs --force --create=tuftree/staged bb",
+	)
+	if tgt, _ := d.PendingBaseTarget(); tgt != nil {
+		t.Errorf("Non-urgent base should only be staged: %s", tgt.Name)
+	}
+
+	// An urgent base takes along the personality it requires
+	if err := saveTarget(p.StateFile(), newTestTarget("v1", oldHash, fmt.Sprintf(custom, ""))); err != nil {
+		t.Fatal(err)
+	}
+	runner = newFakeRunner().
+		on("docker pull hub.foundries.io/web:2", "", nil).
+		on("docker-compose -f docker-compose.yml stop", "", nil).
+		on("docker-compose -f docker-compose.yml up -d", "", nil).
+		on("ostree pull tuftree cc", "", nil).
+		on("ostree admin deploy cc", "", nil)
+	d.setRunner(runner)
+	base := newTestTarget("v3-intel", "cc", `{"targetFormat": "OSTREE", "ostree": "http://example.com", "personalityVersions": ">=v2", "urgent": true}`)
+	plan = &UpdatePlan{Steps: []PlannedUpdate{
+		{Personality: p, Target: newTestTarget("v2", hash, fmt.Sprintf(custom, ""))},
+		{Target: base},
+	}}
+	if err := d.ApplyPlanAutomaticContext(context.Background(), plan, outside); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner,
+		"docker pull hub.foundries.io/web:2",
+		"docker-compose -f docker-compose.yml stop",
+		"docker-compose -f docker-compose.yml up -d",
+		"ostree show cc",
+		"ostree pull tuftree cc",
+		"ostree admin deploy cc",
+	)
+}
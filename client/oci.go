@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+)
+
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociArtifact struct {
+	registry   string
+	repository string
+	digest     string
+}
+
+// Parses an artifact reference like registry/repo@sha256:<digest>. Only
+// references pinned by digest are accepted so that the manifest itself is
+// immutable.
+func parseOCIArtifact(ref string) (*ociArtifact, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return nil, fmt.Errorf("%s must be pinned by digest: <registry>/<repository>@sha256:<digest>", ref)
+	}
+	registry := reference.Domain(named)
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	return &ociArtifact{
+		registry:   registry,
+		repository: reference.Path(named),
+		digest:     canonical.Digest().String(),
+	}, nil
+}
+
+// Follow docker's convention of talking plain http to local registries
+func (a ociArtifact) serverURL() string {
+	host := a.registry
+	if idx := strings.LastIndex(host, ":"); idx > 0 {
+		host = host[:idx]
+	}
+	if host == "localhost" || strings.HasPrefix(host, "127.") {
+		return "http://" + a.registry
+	}
+	return "https://" + a.registry
+}
+
+func (a ociArtifact) get(ctx context.Context, client *http.Client, kind, digest string, accept []string) ([]byte, error) {
+	url := fmt.Sprintf("%s/v2/%s/%s/%s", a.serverURL(), a.repository, kind, digest)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, mediaType := range accept {
+		req.Header.Add("Accept", mediaType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{Url: url, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &NetworkError{Url: url, Err: fmt.Errorf("HTTP_%d", resp.StatusCode), StatusCode: resp.StatusCode}
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &NetworkError{Url: url, Err: err}
+	}
+	return buf, nil
+}
+
+// pullArtifact downloads the layer of an OCI artifact whose sha256 matches
+// the TUF target's hash and saves it to dstFile
+func pullArtifact(ctx context.Context, dstFile, ref string, h httpClients, hash string) error {
+	artifact, err := parseOCIArtifact(ref)
+	if err != nil {
+		return err
+	}
+	transport, err := h.registryTransport(ctx, artifact.serverURL(), artifact.repository)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport}
+
+	logrus.Debugf("Fetching OCI manifest %s", ref)
+	buf, err := artifact.get(ctx, client, "manifests", artifact.digest, ociManifestTypes)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf)
+	found := "sha256:" + hex.EncodeToString(sum[:])
+	if found != artifact.digest {
+		return &HashMismatchError{ref, artifact.digest, found}
+	}
+	manifest := ociManifest{}
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return fmt.Errorf("Unable to parse OCI manifest of %s: %s", ref, err)
+	}
+
+	var layer *ociDescriptor
+	for idx := range manifest.Layers {
+		if manifest.Layers[idx].Digest == "sha256:"+hash {
+			layer = &manifest.Layers[idx]
+			break
+		}
+	}
+	if layer == nil {
+		return fmt.Errorf("OCI artifact %s has no layer matching sha256:%s", ref, hash)
+	}
+
+	logrus.Debugf("Fetching OCI layer %s(%s)", layer.Digest, layer.MediaType)
+	buf, err = artifact.get(ctx, client, "blobs", layer.Digest, nil)
+	if err != nil {
+		return err
+	}
+	return saveVerified(dstFile, ref, buf, hash)
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/docker/go/canonical/json"
+)
+
+func sha256Hex(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates a stand-in registry serving a single artifact, returning the
+// reference to it and the hash of its layer
+func createRegistry(t *testing.T, layer []byte) (*httptest.Server, string, string) {
+	layerHash := sha256Hex(layer)
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestTypes[0],
+		Layers: []ociDescriptor{
+			{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: "sha256:" + sha256Hex([]byte("other")), Size: 5},
+			{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: "sha256:" + layerHash, Size: int64(len(layer))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDigest := "sha256:" + sha256Hex(manifest)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(200)
+		case "/v2/foo/bar/manifests/" + manifestDigest:
+			w.Header().Set("Content-Type", ociManifestTypes[0])
+			w.Write(manifest)
+		case "/v2/foo/bar/blobs/sha256:" + layerHash:
+			w.Write(layer)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	server := httptest.NewServer(mux)
+	ref := strings.TrimPrefix(server.URL, "http://") + "/foo/bar@" + manifestDigest
+	return server, ref, layerHash
+}
+
+func TestParseOCIArtifact(t *testing.T) {
+	digest := "sha256:" + sha256Hex([]byte("foo"))
+	a, err := parseOCIArtifact("hub.foundries.io/foo/bar@" + digest)
+	if err != nil {
+		t.Fatalf("Unable to parse artifact: %s", err)
+	}
+	if a.registry != "hub.foundries.io" || a.repository != "foo/bar" || a.digest != digest {
+		t.Errorf("Invalid artifact parsing: %v", a)
+	}
+	if a.serverURL() != "https://hub.foundries.io" {
+		t.Errorf("Invalid server url: %s", a.serverURL())
+	}
+
+	if _, err := parseOCIArtifact("hub.foundries.io/foo/bar:latest"); err == nil {
+		t.Error("Artifacts must be pinned by digest")
+	}
+}
+
+func TestPullArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layer := createArchive(t, ArchiveTarGz, map[string]string{"docker-compose.yml": "{}"})
+	server, ref, hash := createRegistry(t, layer)
+	defer server.Close()
+
+	dst := path.Join(dir, hash+".tgz")
+	if err := pullArtifact(context.Background(), dst, ref, httpClients{}, hash); err != nil {
+		t.Fatalf("Unable to pull artifact: %s", err)
+	}
+	if _, err := validateArchive(dst, hash, ""); err != nil {
+		t.Errorf("Pulled artifact is invalid: %s", err)
+	}
+
+	// The TUF target must match a layer of the artifact
+	bad := sha256Hex([]byte("not a layer"))
+	if err := pullArtifact(context.Background(), path.Join(dir, bad), ref, httpClients{}, bad); err == nil {
+		t.Error("pullArtifact should fail when no layer matches the target hash")
+	} else {
+		t.Logf("Error message: %s", err)
+	}
+
+	// The manifest must match the pinned digest
+	badRef := ref[:strings.Index(ref, "@")] + "@sha256:" + bad
+	if err := pullArtifact(context.Background(), path.Join(dir, "x"), badRef, httpClients{}, hash); err == nil {
+		t.Error("pullArtifact should fail with an unknown manifest")
+	}
+}
+
+func TestOCIDockerComposeCustom(t *testing.T) {
+	custom := json.RawMessage([]byte(`{
+		"targetFormat": "DOCKER_COMPOSE",
+		"ociArtifact": "hub.foundries.io/foo/bar@sha256:` + sha256Hex([]byte("foo")) + `"
+	}`))
+	c, err := NotaryClient{}.DockerCompose(&custom)
+	if err != nil {
+		t.Fatalf("DOCKER_COMPOSE parsing failed with: %s", err)
+	}
+	if len(c.OCIArtifact) == 0 {
+		t.Error("DOCKER_COMPOSE ociArtifact not parsed")
+	}
+
+	custom = json.RawMessage([]byte(`{
+		"targetFormat": "DOCKER_COMPOSE",
+		"ociArtifact": "hub.foundries.io/foo/bar:latest"
+	}`))
+	_, err = NotaryClient{}.DockerCompose(&custom)
+	if err == nil {
+		t.Error("DOCKER_COMPOSE parsing should have failed")
+	}
+}
@@ -1,15 +1,24 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-func NewOSTreeStatus() (*OSTreeStatus, error) {
-	out, err := Run("ostree", "admin", "status")
+// Where OSTree reads remote configs from
+const DefaultOSTreeRemotesDir = "/etc/ostree/remotes.d"
+
+// Keeps a commit staged outside a maintenance window from being pruned
+// before it's deployed. Staging moves it to the new commit.
+const OSTreeStagedRef = "tuftree/staged"
+
+func NewOSTreeStatus(r Runner) (*OSTreeStatus, error) {
+	out, err := runWith(context.Background(), r, "", "ostree", "admin", "status")
 	if err != nil {
 		return nil, err
 	}
@@ -25,14 +34,26 @@ func NewOSTreeStatus() (*OSTreeStatus, error) {
 				idx := strings.Index(fields[1], ".")
 				sts := fields[1][:idx]
 				status.Pending = &sts
+			} else if len(fields) == 3 && fields[2] == "(rollback)" {
+				idx := strings.Index(fields[1], ".")
+				sts := fields[1][:idx]
+				status.Rollback = &sts
 			}
 		}
 	}
 	return &status, nil
 }
 
-func OSTreeAddRemote(label string, url string, ignoreGPG bool) error {
-	fd, err := os.Create("/etc/ostree/remotes.d/" + label + ".conf")
+// Returns true if the commit's objects are already in the local repository
+func OSTreeHasCommit(r Runner, hash string) bool {
+	_, err := runWith(context.Background(), r, "", "ostree", "show", hash)
+	return err == nil
+}
+
+// OSTreeAddRemote configures a remote. The client certificate and key are
+// presented to servers requiring mutual TLS unless empty.
+func OSTreeAddRemote(remotesDir, label, url string, ignoreGPG bool, tlsCert, tlsKey string) error {
+	fd, err := os.Create(path.Join(remotesDir, label+".conf"))
 	if err != nil {
 		return fmt.Errorf("Unable to create ostree remote config: %s", err)
 	}
@@ -43,18 +64,54 @@ func OSTreeAddRemote(label string, url string, ignoreGPG bool) error {
 	if ignoreGPG {
 		fd.WriteString("gpg-verify=false\n")
 	}
+	if len(tlsCert) > 0 {
+		fd.WriteString("tls-client-cert-path=" + tlsCert + "\n")
+		fd.WriteString("tls-client-key-path=" + tlsKey + "\n")
+	}
 	return nil
 }
 
-func OSTreeUpdate(remote string, hash string) error {
-	logrus.Infof("Pulling ostree objects for %s:%s", remote, hash)
-	if err := RunStreamed("ostree", "pull", remote, hash); err != nil {
+func OSTreeUpdate(r Runner, remote string, hash string) error {
+	return OSTreeUpdateContext(context.Background(), r, remote, hash)
+}
+
+// OSTreeUpdateContext pulls and deploys a commit. The pull is killed once
+// ctx is done or the pull timeout expires.
+func OSTreeUpdateContext(ctx context.Context, r Runner, remote string, hash string) error {
+	if err := OSTreePullContext(ctx, r, remote, hash); err != nil {
 		return err
 	}
 
 	logrus.Infof("Deploying ostree image %s:%s", remote, hash)
-	if err := RunStreamed("ostree", "admin", "deploy", hash); err != nil {
-		return err
+	return OSTreeDeployContext(ctx, r, hash)
+}
+
+// OSTreePullContext pulls a commit into the local repository unless it's
+// already there, e.g. staged by an earlier run, so no network is needed
+func OSTreePullContext(ctx context.Context, r Runner, remote string, hash string) error {
+	if OSTreeHasCommit(r, hash) {
+		logrus.Infof("Commit %s is already in the local repository", hash)
+		return nil
 	}
-	return nil
+	logrus.Infof("Pulling ostree objects for %s:%s", remote, hash)
+	return retry(ctx, "Pulling ostree objects", func() error {
+		ctx, cancel := phaseContext(ctx, pullPhase)
+		defer cancel()
+		return runStreamedWith(ctx, r, "", "ostree", "pull", remote, hash)
+	})
+}
+
+// OSTreeSetRef points a local ref at a commit, replacing its previous one
+func OSTreeSetRef(ctx context.Context, r Runner, ref, hash string) error {
+	_, err := runWith(ctx, r, "", "ostree", "refs", "--force", "--create="+ref, hash)
+	return err
+}
+
+// Deploys a commit already present in the local repository
+func OSTreeDeploy(r Runner, hash string) error {
+	return OSTreeDeployContext(context.Background(), r, hash)
+}
+
+func OSTreeDeployContext(ctx context.Context, r Runner, hash string) error {
+	return runStreamedWith(ctx, r, "", "ostree", "admin", "deploy", hash)
 }
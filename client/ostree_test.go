@@ -1,7 +1,7 @@
 package client
 
 import (
-	"os/exec"
+	"context"
 	"strings"
 	"testing"
 )
@@ -13,10 +13,7 @@ func TestOSTreeStatus(t *testing.T) {
   lmp f315bbe0cde9125f91ca3faee238df121fbb0ad20499b11148402ee7f0fb1859.0 (rollback)
     origin refspec: f315bbe0cde9125f91ca3faee238df121fbb0ad20499b11148402ee7f0fb1859
 `)
-	execCommand = NewMockExec(simple, "", 0)
-	defer func() { execCommand = exec.Command }()
-
-	status, err := NewOSTreeStatus()
+	status, err := NewOSTreeStatus(newFakeRunner().on("ostree admin status", simple, nil))
 	if err != nil {
 		t.Error(err)
 	}
@@ -26,6 +23,11 @@ func TestOSTreeStatus(t *testing.T) {
 	if status.Pending != nil {
 		t.Errorf("Pending should be nil not: %s", *status.Pending)
 	}
+	if status.Rollback == nil {
+		t.Error("Rollback should not be nil")
+	} else if *status.Rollback != "f315bbe0cde9125f91ca3faee238df121fbb0ad20499b11148402ee7f0fb1859" {
+		t.Errorf("Invalid value for rollback image: %s", *status.Rollback)
+	}
 }
 
 func TestOSTreeStatusPending(t *testing.T) {
@@ -35,10 +37,7 @@ func TestOSTreeStatusPending(t *testing.T) {
 *  lmp f315bbe0cde9125f91ca3faee238df121fbb0ad20499b11148402ee7f0fb1859.0
     origin refspec: f315bbe0cde9125f91ca3faee238df121fbb0ad20499b11148402ee7f0fb1859
 `)
-	execCommand = NewMockExec(simple, "", 0)
-	defer func() { execCommand = exec.Command }()
-
-	status, err := NewOSTreeStatus()
+	status, err := NewOSTreeStatus(newFakeRunner().on("ostree admin status", simple, nil))
 	if err != nil {
 		t.Error(err)
 	}
@@ -51,3 +50,13 @@ func TestOSTreeStatusPending(t *testing.T) {
 		t.Errorf("Invalid value for pending image: %s", *status.Pending)
 	}
 }
+
+func TestOSTreeUpdateStaged(t *testing.T) {
+	runner := newFakeRunner().
+		on("ostree show aa", "commit aa", nil).
+		on("ostree admin deploy aa", "", nil)
+	if err := OSTreeUpdateContext(context.Background(), runner, "tuftree", "aa"); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner, "ostree show aa", "ostree admin deploy aa")
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+// The personality configured by the original Personality* fields of the
+// DeviceConfig. It keeps the file layout used before devices could run
+// more than one personality.
+const DefaultPersonality = "default"
+
+var personalityNameRe = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+func newPersonality(configDir string, device DeviceConfig, config PersonalityConfig) (*Personality, error) {
+	if !personalityNameRe.MatchString(config.Name) {
+		return nil, fmt.Errorf("Invalid personality name '%s'", config.Name)
+	}
+	if len(config.CollectionName) == 0 {
+		return nil, fmt.Errorf("Personality(%s) has no notary collection", config.Name)
+	}
+	notary, err := newNotaryClient(path.Join(configDir, "notary"), config.NotaryServerUrl, device.httpClients(configDir, config.NotaryServerUrl, config.NotaryCAFile), config.AllowedRoles)
+	if err != nil {
+		return nil, fmt.Errorf("Personality(%s): %s", config.Name, err)
+	}
+	return &Personality{
+		configDir: configDir,
+		Config:    config,
+		Notary:    notary,
+	}, nil
+}
+
+// Returns the personalities of a device with the legacy one, if configured,
+// listed first
+func newPersonalities(configDir string, config DeviceConfig) ([]*Personality, error) {
+	configs := config.Personalities
+	if len(config.PersonalityCollectionName) > 0 {
+		legacy := PersonalityConfig{
+			Name:            DefaultPersonality,
+			NotaryServerUrl: config.PersonalityNotaryServerUrl,
+			NotaryCAFile:    config.PersonalityNotaryCAFile,
+			CollectionName:  config.PersonalityCollectionName,
+			AllowedRoles:    config.PersonalityAllowedRoles,
+		}
+		configs = append([]PersonalityConfig{legacy}, configs...)
+	}
+
+	var personalities []*Personality
+	names := make(map[string]bool)
+	for _, pc := range configs {
+		if names[pc.Name] {
+			return nil, fmt.Errorf("Duplicate personality name '%s'", pc.Name)
+		}
+		names[pc.Name] = true
+		p, err := newPersonality(configDir, config, pc)
+		if err != nil {
+			return nil, err
+		}
+		personalities = append(personalities, p)
+	}
+	return personalities, nil
+}
+
+func (p *Personality) Name() string {
+	return p.Config.Name
+}
+
+// The file holding the currently installed target
+func (p *Personality) StateFile() string {
+	if p.Config.Name == DefaultPersonality {
+		return path.Join(p.configDir, "personality.json")
+	}
+	return path.Join(p.configDir, "personality-"+p.Config.Name+".json")
+}
+
+// The directory docker-compose is run from. Its name is used by
+// docker-compose as the project name, so each personality gets its own.
+func (p *Personality) ComposeDir() string {
+	if p.Config.Name == DefaultPersonality {
+		return path.Join(p.configDir, "docker-compose-current")
+	}
+	return path.Join(p.configDir, "personalities", p.Config.Name)
+}
+
+// Archives are cached by hash, so all personalities can share a cache
+func (p *Personality) CacheDir() string {
+	return path.Join(p.configDir, "docker-compose-cache")
+}
+
+func (p *Personality) Targets() ([]*client.TargetWithRole, error) {
+	return p.TargetsContext(context.Background())
+}
+
+func (p *Personality) TargetsContext(ctx context.Context) ([]*client.TargetWithRole, error) {
+	return p.Notary.TargetsContext(ctx, p.Config.CollectionName)
+}
+
+// TargetList lists the personality's targets, falling back to the cached
+// TUF metadata when the notary server can't be reached
+func (p *Personality) TargetList(ctx context.Context) (*TargetList, error) {
+	return p.Notary.ListTargets(ctx, p.Config.CollectionName)
+}
+
+func (p *Personality) Target() (*client.TargetWithRole, *DockerComposeCustom, error) {
+	bytes, err := ioutil.ReadFile(p.StateFile())
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to find configured personality target: %s", err)
+	}
+	target := client.TargetWithRole{}
+	if err := json.Unmarshal(bytes, &target); err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse configured personality target: %s", err)
+	}
+	if target.Custom == nil || target.Name == "" {
+		return nil, nil, fmt.Errorf("Invalid base target data: %s", bytes)
+	}
+	dcc, err := NotaryClient{}.DockerCompose(target.Custom)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid DOCKER_COMPOSE custom data: %s", err)
+	}
+	return &target, dcc, nil
+}
+
+// Records the target staged for the next maintenance window, so cleanup
+// keeps its archive until it's installed
+func (p *Personality) stagedFile() string {
+	return strings.TrimSuffix(p.StateFile(), ".json") + ".staged.json"
+}
+
+// Returns the sha256 of the staged archive, empty when there is none
+func (p *Personality) stagedHash() string {
+	bytes, err := ioutil.ReadFile(p.stagedFile())
+	if err != nil {
+		return ""
+	}
+	target := client.TargetWithRole{}
+	if err := json.Unmarshal(bytes, &target); err != nil {
+		logrus.Warnf("Unable to parse staged personality(%s) target: %s", p.Config.Name, err)
+		return ""
+	}
+	return hex.EncodeToString(target.Hashes["sha256"])
+}
+
+// StageContext downloads the target's archive and pulls its images without
+// touching the running containers
+func (p *Personality) StageContext(ctx context.Context, target *client.TargetWithRole) error {
+	logrus.Infof("Fetching personality(%s) version %s", p.Config.Name, target.Name)
+	if _, err := p.stage(ctx, target); err != nil {
+		return err
+	}
+	return saveTarget(p.stagedFile(), target)
+}
+
+func (p *Personality) stage(ctx context.Context, target *client.TargetWithRole) (*DockerComposeUpdater, error) {
+	cacheDir := p.CacheDir()
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create docker-compose cache: %s", err)
+	}
+	custom, err := p.Notary.DockerCompose(target.Custom)
+	if err != nil {
+		return nil, err
+	}
+	desired := hex.EncodeToString(target.Hashes["sha256"])
+	return NewComposeUpdaterContext(ctx, p.Runner, p.Notary, cacheDir, desired, *custom)
+}
+
+func (p *Personality) Update(target *client.TargetWithRole) error {
+	return p.UpdateContext(context.Background(), target)
+}
+
+// UpdateContext installs the target and restarts the personality's
+// containers, aborting once ctx is done
+func (p *Personality) UpdateContext(ctx context.Context, target *client.TargetWithRole) error {
+	composeDir := p.ComposeDir()
+	if err := os.MkdirAll(composeDir, 0700); err != nil {
+		return fmt.Errorf("Unable to create docker-compose directory: %s", err)
+	}
+
+	logrus.Infof("Updating personality(%s) to version %s", p.Config.Name, target.Name)
+	new, err := p.stage(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	oldTgt, custom, err := p.Target()
+	if err != nil {
+		logrus.Warnf("Error loading current personality, assuming initial run: %s", err)
+	} else {
+		hash := hex.EncodeToString(oldTgt.Hashes["sha256"])
+		old, err := NewComposeUpdaterContext(ctx, p.Runner, p.Notary, p.CacheDir(), hash, *custom)
+		if err != nil {
+			logrus.Warnf("Unable to load old personality, skipping docker-compose-stop: %s", err)
+		} else {
+			logrus.Info("Stopping old set of docker-compose containers")
+			if err := old.StopContext(ctx, composeDir); err != nil {
+				logrus.Warnf("Unable to stop old personality, continuing with fingers crossed: %s", err)
+			}
+		}
+	}
+
+	logrus.Info("Starting new docker-compose containers")
+	if err := new.StartContext(ctx, composeDir); err != nil {
+		return fmt.Errorf("Unable to start new personality: %s", err)
+	}
+	if err := saveTarget(p.StateFile(), target); err != nil {
+		return err
+	}
+	if err := os.Remove(p.stagedFile()); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Unable to remove staged personality(%s) target: %s", p.Config.Name, err)
+	}
+	return nil
+}
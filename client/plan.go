@@ -0,0 +1,375 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/docker/cli/cli/compose/types"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+type PlannedUpdate struct {
+	// nil when the update is for the base image
+	Personality *Personality
+	Target      *client.TargetWithRole
+	// Explains why an update was deferred
+	Reason string
+}
+
+// UpdatePlan orders base and personality updates so that a device never
+// runs a personality its base image isn't compatible with. Base updates
+// only take effect after a reboot, so a personality that needs the new base
+// is deferred until the device is running it.
+type UpdatePlan struct {
+	Steps    []PlannedUpdate
+	Deferred []PlannedUpdate
+	// Recorded in the update history, e.g. "manual" or "cron"
+	Trigger string
+}
+
+type baseVersion struct {
+	version string
+	custom  *OSTreeCustom
+}
+
+type personalityVersion struct {
+	name    string
+	version string
+	custom  *DockerComposeCustom
+}
+
+// Returns an error explaining why a personality can't run on a base. Unknown
+// versions can't be checked and are assumed to be compatible.
+func checkCompatible(base *baseVersion, p *personalityVersion) error {
+	if base == nil || p == nil {
+		return nil
+	}
+	ok, err := versionInRange(base.version, p.custom.BaseVersions)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("personality(%s) %s requires base %s, not %s", p.name, p.version, p.custom.BaseVersions, base.version)
+	}
+	ok, err = versionInRange(p.version, base.custom.PersonalityVersions)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("base %s requires personality %s, not personality(%s) %s", base.version, base.custom.PersonalityVersions, p.name, p.version)
+	}
+	return nil
+}
+
+func newBaseVersion(target *client.TargetWithRole) (*baseVersion, error) {
+	custom, err := NotaryClient{}.OSTree(target.Custom)
+	if err != nil {
+		return nil, err
+	}
+	ver, _, err := BaseVersionSplit(target.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &baseVersion{ver, custom}, nil
+}
+
+func (d *Device) currentBase() *baseVersion {
+	tgt, custom, err := d.BaseTarget()
+	if err != nil {
+		logrus.Debugf("Unable to determine current base version: %s", err)
+		return nil
+	}
+	if hex.EncodeToString(tgt.Hashes["sha256"]) != d.OSTreeStatus.Active {
+		logrus.Warnf("Base %s is not the active deployment, unable to check its compatibility", tgt.Name)
+		return nil
+	}
+	ver, _, err := BaseVersionSplit(tgt.Name)
+	if err != nil {
+		logrus.Debugf("Unable to determine current base version: %s", err)
+		return nil
+	}
+	return &baseVersion{ver, custom}
+}
+
+func (p *Personality) current() *personalityVersion {
+	tgt, custom, err := p.Target()
+	if err != nil {
+		logrus.Debugf("Unable to determine current personality(%s) version: %s", p.Name(), err)
+		return nil
+	}
+	return &personalityVersion{p.Name(), tgt.Name, custom}
+}
+
+// PlanUpdate determines the order in which the base target and the targets
+// for each of the given personalities should be applied. An error is
+// returned if no ordering keeps the device running a compatible combination.
+func (d *Device) PlanUpdate(base *client.TargetWithRole, personalities []*Personality, targets []*client.TargetWithRole) (*UpdatePlan, error) {
+	current := d.currentBase()
+	desired := current
+	baseChanging := false
+	if base != nil {
+		var err error
+		if desired, err = newBaseVersion(base); err != nil {
+			return nil, err
+		}
+		baseChanging = hex.EncodeToString(base.Hashes["sha256"]) != d.OSTreeStatus.Active
+		if !baseChanging {
+			current = desired
+		}
+	}
+
+	plan := UpdatePlan{}
+	updating := make(map[*Personality]bool)
+	for idx, p := range personalities {
+		updating[p] = true
+		custom, err := NotaryClient{}.DockerCompose(targets[idx].Custom)
+		if err != nil {
+			return nil, err
+		}
+		next := &personalityVersion{p.Name(), targets[idx].Name, custom}
+		step := PlannedUpdate{Personality: p, Target: targets[idx]}
+
+		if err := checkCompatible(desired, next); err != nil {
+			return nil, fmt.Errorf("Refusing update: %s", err)
+		}
+		nowErr := checkCompatible(current, next)
+		if nowErr == nil {
+			plan.Steps = append(plan.Steps, step)
+			continue
+		}
+		if !baseChanging {
+			return nil, fmt.Errorf("Refusing update: %s", nowErr)
+		}
+		// The personality must wait for the new base, so the installed one
+		// has to keep working on the new base until then
+		if err := checkCompatible(desired, p.current()); err != nil {
+			return nil, fmt.Errorf("Refusing update: %s and %s", nowErr, err)
+		}
+		step.Reason = nowErr.Error()
+		plan.Deferred = append(plan.Deferred, step)
+	}
+
+	if baseChanging {
+		for _, p := range d.Personalities {
+			if updating[p] {
+				continue
+			}
+			if err := checkCompatible(desired, p.current()); err != nil {
+				return nil, fmt.Errorf("Refusing update: %s", err)
+			}
+		}
+	}
+	if base != nil {
+		// Deploying it again would only record another update
+		if hash := hex.EncodeToString(base.Hashes["sha256"]); d.basePending(hash) {
+			logrus.Infof("Base %s is already deployed and waiting for a reboot", base.Name)
+		} else {
+			plan.Steps = append(plan.Steps, PlannedUpdate{Target: base})
+		}
+	}
+	return &plan, nil
+}
+
+// ApplyPlan performs the steps of an update plan in order, recording each
+// of them in the device's history
+func (d *Device) ApplyPlan(plan *UpdatePlan) error {
+	return d.ApplyPlanContext(context.Background(), plan)
+}
+
+// ApplyPlanContext is ApplyPlan with the steps aborted once ctx is done.
+// Steps after an aborted one aren't attempted.
+func (d *Device) ApplyPlanContext(ctx context.Context, plan *UpdatePlan) error {
+	history := d.History()
+	for _, step := range plan.Steps {
+		var err error
+		if step.Personality == nil {
+			from, _, _ := d.BaseTarget()
+			entry := newHistoryEntry(HistoryBase, from, step.Target, plan.Trigger)
+			err = d.UpdateBaseContext(ctx, step.Target)
+			history.record(entry, err)
+		} else {
+			from, _, _ := step.Personality.Target()
+			entry := newHistoryEntry(HistoryPersonality, from, step.Target, plan.Trigger)
+			entry.Personality = step.Personality.Name()
+			err = step.Personality.UpdateContext(ctx, step.Target)
+			history.record(entry, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, step := range plan.Deferred {
+		logrus.Warnf("Personality(%s) update to %s deferred until the new base is running: %s",
+			step.Personality.Name(), step.Target.Name, step.Reason)
+	}
+	if len(plan.Steps) > 0 && !d.Config.Retention.DisableAutoCleanup {
+		if err := d.Cleanup(d.CleanupOptions()); err != nil {
+			logrus.Warnf("Unable to clean up after update: %s", err)
+		}
+	}
+	return nil
+}
+
+type BaseReport struct {
+	Target      string `json:"target"`
+	Role        string `json:"role"`
+	CurrentHash string `json:"currentHash"`
+	DesiredHash string `json:"desiredHash"`
+	OSTreeUrl   string `json:"ostreeUrl"`
+	Pull        bool   `json:"pull"`
+	Deploy      bool   `json:"deploy"`
+	Size        int64  `json:"size"`
+}
+
+type ComposeImage struct {
+	Image  string `json:"image"`
+	Signed bool   `json:"signed"`
+}
+
+type PersonalityReport struct {
+	Name          string         `json:"name"`
+	Target        string         `json:"target"`
+	Role          string         `json:"role"`
+	CurrentTarget string         `json:"currentTarget,omitempty"`
+	CurrentHash   string         `json:"currentHash,omitempty"`
+	DesiredHash   string         `json:"desiredHash"`
+	Source        string         `json:"source"`
+	Cached        bool           `json:"cached"`
+	Size          int64          `json:"size"`
+	Images        []ComposeImage `json:"images,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Start         []string       `json:"start,omitempty"`
+	Deferred      bool           `json:"deferred"`
+	Reason        string         `json:"reason,omitempty"`
+}
+
+// PlanReport describes what applying an UpdatePlan would do to the device
+type PlanReport struct {
+	Base          *BaseReport         `json:"base,omitempty"`
+	Personalities []PersonalityReport `json:"personalities"`
+	// Bytes that must be downloaded. Sizes unknown from the targets metadata
+	// count as 0
+	DiskRequired  int64  `json:"diskRequired"`
+	DiskAvailable uint64 `json:"diskAvailable"`
+}
+
+func serviceNames(project *types.Config) []string {
+	var names []string
+	for _, svc := range project.Services {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+func (d *Device) describeBase(target *client.TargetWithRole) (*BaseReport, error) {
+	custom, err := NotaryClient{}.OSTree(target.Custom)
+	if err != nil {
+		return nil, err
+	}
+	desired := hex.EncodeToString(target.Hashes["sha256"])
+	r := BaseReport{
+		Target:      target.Name,
+		Role:        target.Role.String(),
+		CurrentHash: d.OSTreeStatus.Active,
+		DesiredHash: desired,
+		OSTreeUrl:   custom.Url,
+	}
+	if desired != d.OSTreeStatus.Active {
+		r.Deploy = true
+		r.Pull = !OSTreeHasCommit(d.Runner, desired)
+		if r.Pull {
+			r.Size = target.Length
+		}
+	}
+	return &r, nil
+}
+
+func (p *Personality) describe(step PlannedUpdate, deferred bool) (*PersonalityReport, error) {
+	custom, err := NotaryClient{}.DockerCompose(step.Target.Custom)
+	if err != nil {
+		return nil, err
+	}
+	desired := hex.EncodeToString(step.Target.Hashes["sha256"])
+	r := PersonalityReport{
+		Name:        p.Name(),
+		Target:      step.Target.Name,
+		Role:        step.Target.Role.String(),
+		DesiredHash: desired,
+		Source:      custom.TgzUrl,
+		Deferred:    deferred,
+		Reason:      step.Reason,
+	}
+	if len(custom.OCIArtifact) > 0 {
+		r.Source = custom.OCIArtifact
+	}
+
+	archive := cachedArchive(p.CacheDir(), desired)
+	if _, err := os.Stat(archive); err == nil {
+		r.Cached = true
+		project, err := loadComposeProject(archive, desired, *custom)
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range project.Services {
+			r.Images = append(r.Images, ComposeImage{svc.Image, isSignedImage(svc.Image)})
+		}
+		r.Start = serviceNames(project)
+	} else {
+		r.Size = step.Target.Length
+	}
+
+	if cur, curCustom, err := p.Target(); err == nil {
+		r.CurrentTarget = cur.Name
+		r.CurrentHash = hex.EncodeToString(cur.Hashes["sha256"])
+		project, err := loadComposeProject(cachedArchive(p.CacheDir(), r.CurrentHash), r.CurrentHash, *curCustom)
+		if err != nil {
+			logrus.Debugf("Unable to load current personality(%s): %s", p.Name(), err)
+		} else {
+			r.Stop = serviceNames(project)
+		}
+	}
+	return &r, nil
+}
+
+// DescribePlan reports what an update plan would do without changing
+// anything on the device. Only archives already in the cache are inspected,
+// so images and services of uncached personalities are unknown.
+func (d *Device) DescribePlan(plan *UpdatePlan) (*PlanReport, error) {
+	report := PlanReport{Personalities: []PersonalityReport{}}
+	for _, step := range plan.Steps {
+		if step.Personality == nil {
+			r, err := d.describeBase(step.Target)
+			if err != nil {
+				return nil, err
+			}
+			report.Base = r
+			report.DiskRequired += r.Size
+			continue
+		}
+		r, err := step.Personality.describe(step, false)
+		if err != nil {
+			return nil, err
+		}
+		report.Personalities = append(report.Personalities, *r)
+		report.DiskRequired += r.Size
+	}
+	for _, step := range plan.Deferred {
+		r, err := step.Personality.describe(step, true)
+		if err != nil {
+			return nil, err
+		}
+		report.Personalities = append(report.Personalities, *r)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(d.configDir, &stat); err != nil {
+		logrus.Warnf("Unable to determine free disk space: %s", err)
+	} else {
+		report.DiskAvailable = stat.Bavail * uint64(stat.Bsize)
+	}
+	return &report, nil
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/theupdateframework/notary/client"
+)
+
+func newTestTarget(name, hash, custom string) *client.TargetWithRole {
+	raw := json.RawMessage([]byte(custom))
+	sum, _ := hex.DecodeString(hash)
+	tgt := client.TargetWithRole{}
+	tgt.Name = name
+	tgt.Hashes = map[string][]byte{"sha256": sum}
+	tgt.Custom = &raw
+	return &tgt
+}
+
+func baseTestTarget(name, hash, personalityVersions string) *client.TargetWithRole {
+	return newTestTarget(name, hash, `{"targetFormat": "OSTREE", "ostree": "http://example.com", "personalityVersions": "`+personalityVersions+`"}`)
+}
+
+func personalityTestTarget(name, baseVersions string) *client.TargetWithRole {
+	return newTestTarget(name, "00", `{"targetFormat": "DOCKER_COMPOSE", "tgz": "http://example.com", "baseVersions": "`+baseVersions+`"}`)
+}
+
+// Creates a device running base v1 and personality v1
+func newPlanDevice(t *testing.T, dir string) (*Device, *Personality) {
+	d := Device{configDir: dir, OSTreeStatus: &OSTreeStatus{Active: "aa"}}
+	if err := saveTarget(path.Join(dir, "base.json"), baseTestTarget("v1-intel", "aa", "")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPersonality(dir, DeviceConfig{}, PersonalityConfig{Name: DefaultPersonality, CollectionName: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveTarget(p.StateFile(), personalityTestTarget("v1", "<v3")); err != nil {
+		t.Fatal(err)
+	}
+	d.Personalities = []*Personality{p}
+	d.setRunner(newFakeRunner())
+	return &d, p
+}
+
+func TestPlanUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, p := newPlanDevice(t, dir)
+	personalities := []*Personality{p}
+
+	// Compatible with both bases: personality goes first
+	base := baseTestTarget("v2-intel", "bb", "")
+	plan, err := d.PlanUpdate(base, personalities, []*client.TargetWithRole{personalityTestTarget("v2", ">=v1")})
+	if err != nil {
+		t.Fatalf("Unable to plan update: %s", err)
+	}
+	if len(plan.Steps) != 2 || plan.Steps[0].Personality != p || plan.Steps[1].Personality != nil {
+		t.Errorf("Invalid update plan: %v", plan.Steps)
+	}
+
+	// Needs the new base: personality is deferred
+	plan, err = d.PlanUpdate(base, personalities, []*client.TargetWithRole{personalityTestTarget("v2", ">=v2")})
+	if err != nil {
+		t.Fatalf("Unable to plan update: %s", err)
+	}
+	if len(plan.Steps) != 1 || plan.Steps[0].Personality != nil || len(plan.Deferred) != 1 {
+		t.Errorf("Invalid update plan: %v %v", plan.Steps, plan.Deferred)
+	}
+
+	// Can't run on the new base
+	_, err = d.PlanUpdate(base, personalities, []*client.TargetWithRole{personalityTestTarget("v2", "<v2")})
+	if err == nil {
+		t.Error("Incompatible update should be refused")
+	} else {
+		t.Logf("Error message: %s", err)
+	}
+
+	// The installed personality can't run on v3, and the new one needs it
+	base = baseTestTarget("v3-intel", "cc", "")
+	_, err = d.PlanUpdate(base, personalities, []*client.TargetWithRole{personalityTestTarget("v2", ">=v3")})
+	if err == nil {
+		t.Error("Incompatible update should be refused")
+	} else {
+		t.Logf("Error message: %s", err)
+	}
+
+	// Base requires a newer personality than the installed one
+	base = baseTestTarget("v2-intel", "bb", ">=v2")
+	_, err = d.PlanUpdate(base, nil, nil)
+	if err == nil {
+		t.Error("Incompatible base update should be refused")
+	} else {
+		t.Logf("Error message: %s", err)
+	}
+}
+
+func TestDescribePlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, p := newPlanDevice(t, dir)
+
+	compose := "version: '3'\nservices:\n  web:\n    image: hub.foundries.io/web:1\n"
+	archive := createArchive(t, ArchiveTarGz, map[string]string{"docker-compose.yml": compose})
+	hash := sha256Hex(archive)
+	if err := os.MkdirAll(p.CacheDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cachedArchive(p.CacheDir(), hash), archive, 0600); err != nil {
+		t.Fatal(err)
+	}
+	target := newTestTarget("v2", hash, `{"targetFormat": "DOCKER_COMPOSE", "tgz": "http://example.com"}`)
+
+	// ostree show fails: the base's commit isn't available locally
+
+	base := baseTestTarget("v2-intel", "bb", "")
+	base.Length = 42
+	plan, err := d.PlanUpdate(base, []*Personality{p}, []*client.TargetWithRole{target})
+	if err != nil {
+		t.Fatalf("Unable to plan update: %s", err)
+	}
+	report, err := d.DescribePlan(plan)
+	if err != nil {
+		t.Fatalf("Unable to describe plan: %s", err)
+	}
+	if report.Base == nil || !report.Base.Pull || !report.Base.Deploy {
+		t.Errorf("Invalid base report: %v", report.Base)
+	}
+	if report.DiskRequired != 42 {
+		t.Errorf("Invalid disk required: %d != 42", report.DiskRequired)
+	}
+	if len(report.Personalities) != 1 {
+		t.Fatalf("Invalid personalities report: %v", report.Personalities)
+	}
+	r := report.Personalities[0]
+	if !r.Cached || r.CurrentTarget != "v1" || r.DesiredHash != hash {
+		t.Errorf("Invalid personality report: %v", r)
+	}
+	if len(r.Images) != 1 || r.Images[0].Image != "hub.foundries.io/web:1" || !r.Images[0].Signed {
+		t.Errorf("Invalid images: %v", r.Images)
+	}
+	if len(r.Start) != 1 || r.Start[0] != "web" {
+		t.Errorf("Invalid services: %v", r.Start)
+	}
+}
+
+// Adds an archive with the given compose file to the personality's cache
+func cacheTestArchive(t *testing.T, p *Personality, compose string) string {
+	archive := createArchive(t, ArchiveTarGz, map[string]string{"docker-compose.yml": compose})
+	hash := sha256Hex(archive)
+	if err := os.MkdirAll(p.CacheDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cachedArchive(p.CacheDir(), hash), archive, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestApplyPlan(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, p := newPlanDevice(t, dir)
+	d.HardwareId = "intel"
+	d.BaseNotary = &NotaryClient{}
+	d.Config.Retention.DisableAutoCleanup = true
+	d.OSTreeRemotesDir = dir
+
+	custom := `{"targetFormat": "DOCKER_COMPOSE", "tgz": "http://example.com"}`
+	oldHash := cacheTestArchive(t, p, "version: '3'\nservices:\n  web:\n    image: web:1\n")
+	if err := saveTarget(p.StateFile(), newTestTarget("v1", oldHash, custom)); err != nil {
+		t.Fatal(err)
+	}
+	hash := cacheTestArchive(t, p, "version: '3'\nservices:\n  web:\n    image: hub.foundries.io/web:2\n")
+	target := newTestTarget("v2", hash, custom)
+	base := baseTestTarget("v2-intel", "bb", "")
+
+	runner := newFakeRunner().
+		on("docker pull hub.foundries.io/web:2", "", nil).
+		on("docker-compose -f docker-compose.yml stop", "", nil).
+		on("docker-compose -f docker-compose.yml up -d", "", nil).
+		on("ostree pull tuftree bb", "", nil).
+		on("ostree admin deploy bb", "", nil)
+	d.setRunner(runner)
+
+	plan, err := d.PlanUpdate(base, []*Personality{p}, []*client.TargetWithRole{target})
+	if err != nil {
+		t.Fatalf("Unable to plan update: %s", err)
+	}
+	if err := d.ApplyPlan(plan); err != nil {
+		t.Fatalf("Unable to apply plan: %s", err)
+	}
+	assertCalls(t, runner,
+		"docker pull hub.foundries.io/web:2",
+		"docker-compose -f docker-compose.yml stop",
+		"docker-compose -f docker-compose.yml up -d",
+		"ostree show bb",
+		"ostree pull tuftree bb",
+		"ostree admin deploy bb",
+	)
+	if tgt, err := d.PendingBaseTarget(); err != nil || tgt == nil || tgt.Name != "v2-intel" {
+		t.Errorf("Pending base not saved: %v %v", tgt, err)
+	}
+	if tgt, _, _ := d.BaseTarget(); tgt.Name != "v1-intel" {
+		t.Errorf("base.json should not change before a reboot: %s", tgt.Name)
+	}
+	if tgt, _, err := p.Target(); err != nil || tgt.Name != "v2" {
+		t.Errorf("Personality target not saved: %v %v", tgt, err)
+	}
+
+	// A failed deploy must not replace the pending base
+	runner = newFakeRunner().
+		on("ostree pull tuftree cc", "", nil).
+		on("ostree admin deploy cc", "No space left on device", fmt.Errorf("exit status 1"))
+	d.setRunner(runner)
+	err = d.ApplyPlan(&UpdatePlan{Steps: []PlannedUpdate{{Target: baseTestTarget("v3-intel", "cc", "")}}})
+	var execErr *ExecError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("Expected ExecError: %v", err)
+	}
+	if tgt, _ := d.PendingBaseTarget(); tgt == nil || tgt.Name != "v2-intel" {
+		t.Errorf("Pending base should not change on a failed deploy: %v", tgt)
+	}
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+const (
+	RebootImmediate = "immediate"
+	RebootDelayed   = "delayed"
+	RebootInWindow  = "window"
+	RebootNever     = "never"
+)
+
+// Recorded in the update history when a deployed base fails to boot
+const bootTrigger = "boot"
+
+var defaultRebootCommand = []string{"systemctl", "reboot"}
+
+// The base target deployed but not yet confirmed running after a reboot
+func (d *Device) pendingBaseFile() string {
+	return path.Join(d.configDir, "base.pending.json")
+}
+
+// PendingBaseTarget returns the base target waiting for a reboot, nil when
+// there is none
+func (d *Device) PendingBaseTarget() (*client.TargetWithRole, error) {
+	bytes, err := ioutil.ReadFile(d.pendingBaseFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read pending base target: %s", err)
+	}
+	target := client.TargetWithRole{}
+	if err := json.Unmarshal(bytes, &target); err != nil {
+		return nil, fmt.Errorf("Unable to parse pending base target: %s", err)
+	}
+	return &target, nil
+}
+
+// Reports whether an ostree hash is deployed and waiting for a reboot
+func (d *Device) basePending(hash string) bool {
+	if d.OSTreeStatus.Pending != nil && *d.OSTreeStatus.Pending == hash {
+		return true
+	}
+	target, err := d.PendingBaseTarget()
+	return err == nil && target != nil && hex.EncodeToString(target.Hashes["sha256"]) == hash
+}
+
+// FinalizeBase is run after booting. It makes the pending base target the
+// current one once its deployment is active. If the device booted into
+// something else the pending target is dropped and an error returned.
+func (d *Device) FinalizeBase() error {
+	target, err := d.PendingBaseTarget()
+	if err != nil || target == nil {
+		return err
+	}
+	hash := hex.EncodeToString(target.Hashes["sha256"])
+	if d.OSTreeStatus.Active == hash {
+		logrus.Infof("Base %s is now active", target.Name)
+		if err := saveTarget(path.Join(d.configDir, "base.json"), target); err != nil {
+			return err
+		}
+		return os.Remove(d.pendingBaseFile())
+	}
+	if d.OSTreeStatus.Pending != nil && *d.OSTreeStatus.Pending == hash {
+		logrus.Infof("Base %s is waiting for a reboot", target.Name)
+		return nil
+	}
+
+	from, _, _ := d.BaseTarget()
+	err = fmt.Errorf("Device booted ostree hash %s rather than base %s (%s)", d.OSTreeStatus.Active, target.Name, hash)
+	d.History().record(newHistoryEntry(HistoryBase, from, target, bootTrigger), err)
+	if rmErr := os.Remove(d.pendingBaseFile()); rmErr != nil {
+		logrus.Warnf("Unable to remove pending base target: %s", rmErr)
+	}
+	return err
+}
+
+// RebootRequired is true when a deployed base is waiting for a reboot
+func (d *Device) RebootRequired() bool {
+	target, err := d.PendingBaseTarget()
+	return err == nil && target != nil
+}
+
+// Returns the reboot delay from config.json
+func (d *Device) rebootDelay() (time.Duration, error) {
+	if len(d.Config.Reboot.Delay) == 0 {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(d.Config.Reboot.Delay)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid Reboot.Delay: %s", err)
+	}
+	return delay, nil
+}
+
+// RebootContext reboots into a pending base according to the configured
+// Reboot.Policy. With force the policy is ignored and the reboot happens
+// now. Nothing is done when no base is pending.
+func (d *Device) RebootContext(ctx context.Context, now time.Time, force bool) error {
+	target, err := d.PendingBaseTarget()
+	if err != nil || target == nil {
+		return err
+	}
+	policy := d.Config.Reboot.Policy
+	if force {
+		policy = RebootImmediate
+	}
+	switch policy {
+	case "", RebootNever:
+		logrus.Warnf("A reboot is required to run base %s", target.Name)
+		return nil
+	case RebootImmediate:
+	case RebootDelayed:
+		delay, err := d.rebootDelay()
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Rebooting into base %s in %s", target.Name, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case RebootInWindow:
+		schedule, err := d.MaintenanceSchedule()
+		if err != nil {
+			return err
+		}
+		if !schedule.Open(now) {
+			logrus.Infof("Reboot into base %s postponed until the maintenance window", target.Name)
+			return nil
+		}
+	default:
+		return fmt.Errorf("Invalid Reboot.Policy '%s', must be %s, %s, %s or %s",
+			policy, RebootImmediate, RebootDelayed, RebootInWindow, RebootNever)
+	}
+	return d.reboot(ctx, target)
+}
+
+func (d *Device) reboot(ctx context.Context, target *client.TargetWithRole) error {
+	if hook := d.Config.Reboot.PreRebootHook; len(hook) > 0 {
+		logrus.Infof("Running pre-reboot hook %s", hook)
+		_, err := d.Runner.Run(ctx, &Command{
+			Name:   "sh",
+			Args:   []string{"-c", hook},
+			Env:    []string{"TUFTREE_BASE_TARGET=" + target.Name},
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("Reboot cancelled by pre-reboot hook: %s", err)
+		}
+	}
+	var stopped []*Personality
+	for _, p := range d.Personalities {
+		if err := p.StopContext(ctx); err != nil {
+			logrus.Warnf("Unable to stop personality(%s), rebooting anyway: %s", p.Name(), err)
+		} else {
+			stopped = append(stopped, p)
+		}
+	}
+	command := d.Config.Reboot.Command
+	if len(command) == 0 {
+		command = defaultRebootCommand
+	}
+	logrus.Infof("Rebooting into base %s", target.Name)
+	err := runStreamedWith(ctx, d.Runner, "", command[0], command[1:]...)
+	if err != nil {
+		// The device keeps running, so its personalities must too. ctx may be
+		// why the reboot failed, so only its timeouts are kept.
+		restartCtx := WithTimeouts(context.Background(), timeouts(ctx))
+		for _, p := range stopped {
+			if startErr := p.StartContext(restartCtx); startErr != nil {
+				logrus.Errorf("Unable to restart personality(%s): %s", p.Name(), startErr)
+			}
+		}
+		return fmt.Errorf("Unable to reboot: %s", err)
+	}
+	return nil
+}
+
+// Returns the updater for the installed target, nil when the personality
+// has never been started
+func (p *Personality) installedUpdater() (*DockerComposeUpdater, error) {
+	target, custom, err := p.Target()
+	if err != nil {
+		return nil, err
+	}
+	hash := hex.EncodeToString(target.Hashes["sha256"])
+	archive := cachedArchive(p.CacheDir(), hash)
+	if _, err := os.Stat(archive); err != nil {
+		return nil, fmt.Errorf("Unable to find cached archive: %s", err)
+	}
+	if _, err := os.Stat(p.ComposeDir()); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return &DockerComposeUpdater{runner: p.Runner, cachedTgz: archive, dcc: *custom}, nil
+}
+
+// StopContext stops the personality's containers so they shut down cleanly
+func (p *Personality) StopContext(ctx context.Context) error {
+	dcu, err := p.installedUpdater()
+	if err != nil || dcu == nil {
+		return err
+	}
+	logrus.Infof("Stopping personality(%s)", p.Name())
+	return dcu.StopContext(ctx, p.ComposeDir())
+}
+
+// StartContext starts the personality's containers again after StopContext
+func (p *Personality) StartContext(ctx context.Context) error {
+	dcu, err := p.installedUpdater()
+	if err != nil || dcu == nil {
+		return err
+	}
+	logrus.Infof("Starting personality(%s)", p.Name())
+	return dcu.StartContext(ctx, p.ComposeDir())
+}
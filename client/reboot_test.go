@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFinalizeBase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reboot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, _ := newPlanDevice(t, dir)
+
+	if err := d.FinalizeBase(); err != nil || d.RebootRequired() {
+		t.Fatalf("Nothing should be pending: %v", err)
+	}
+	if err := saveTarget(d.pendingBaseFile(), baseTestTarget("v2-intel", "bb", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deployed but the device hasn't rebooted yet
+	pending := "bb"
+	d.OSTreeStatus.Pending = &pending
+	if err := d.FinalizeBase(); err != nil || !d.RebootRequired() {
+		t.Fatalf("Base should still be pending: %v", err)
+	}
+
+	d.OSTreeStatus = &OSTreeStatus{Active: "bb"}
+	if err := d.FinalizeBase(); err != nil {
+		t.Fatal(err)
+	}
+	if tgt, _, _ := d.BaseTarget(); tgt.Name != "v2-intel" {
+		t.Errorf("base.json not updated after boot: %s", tgt.Name)
+	}
+	if d.RebootRequired() {
+		t.Error("Pending base not removed after boot")
+	}
+
+	// The boot fell back to the previous deployment
+	if err := saveTarget(d.pendingBaseFile(), baseTestTarget("v3-intel", "cc", "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.FinalizeBase(); err == nil {
+		t.Error("Booting the wrong deployment should fail")
+	}
+	if tgt, _, _ := d.BaseTarget(); tgt.Name != "v2-intel" {
+		t.Errorf("base.json should not change when the boot fails: %s", tgt.Name)
+	}
+	if d.RebootRequired() {
+		t.Error("Failed base should not stay pending")
+	}
+	entries, _ := d.History().Entries()
+	last := entries[len(entries)-1]
+	if last.Trigger != bootTrigger || last.ToTarget != "v3-intel" || last.Result != HistoryFailure {
+		t.Errorf("Failed boot not recorded in history: %v", last)
+	}
+}
+
+func TestUpdateBasePending(t *testing.T) {
+	t.Parallel()
+	d, _ := newPlanDevice(t, t.TempDir())
+	d.BaseNotary = &NotaryClient{}
+	d.HardwareId = "intel"
+	d.OSTreeRemotesDir = t.TempDir()
+	target := baseTestTarget("v2-intel", "bb", "")
+
+	// Deployed, e.g. by a run that failed to save the pending target
+	pending := "bb"
+	d.OSTreeStatus.Pending = &pending
+	runner := newFakeRunner()
+	d.setRunner(runner)
+	if err := d.UpdateBaseContext(context.Background(), target); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner)
+	if !d.RebootRequired() {
+		t.Error("A deployed base should require a reboot")
+	}
+
+	// Updating again while waiting for the reboot is a no-op
+	d.OSTreeStatus.Pending = nil
+	plan, err := d.PlanUpdate(target, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 0 {
+		t.Errorf("Pending base should not be deployed again: %v", plan.Steps)
+	}
+}
+
+func TestReboot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reboot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, p := newPlanDevice(t, dir)
+	custom := `{"targetFormat": "DOCKER_COMPOSE", "tgz": "http://example.com"}`
+	hash := cacheTestArchive(t, p, "version: '3'\nservices:\n  web:\n    image: web:1\n")
+	if err := saveTarget(p.StateFile(), newTestTarget("v1", hash, custom)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(p.ComposeDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	now, _ := time.Parse(time.RFC3339, "2021-03-06T09:00:00Z") // Saturday
+
+	// Nothing pending, nothing to do
+	runner := newFakeRunner()
+	d.setRunner(runner)
+	if err := d.RebootContext(context.Background(), now, true); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner)
+
+	if err := saveTarget(d.pendingBaseFile(), baseTestTarget("v2-intel", "bb", "")); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{"", RebootNever} {
+		d.Config.Reboot = RebootConfig{Policy: policy}
+		if err := d.RebootContext(context.Background(), now, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Config.Reboot = RebootConfig{Policy: RebootInWindow}
+	d.Config.Maintenance = MaintenanceConfig{Timezone: "UTC", Windows: []MaintenanceWindow{{Days: []string{"Sun"}, Start: "01:00", End: "02:00"}}}
+	if err := d.RebootContext(context.Background(), now, false); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner)
+
+	d.Config.Reboot = RebootConfig{Policy: "sometime"}
+	if err := d.RebootContext(context.Background(), now, false); err == nil {
+		t.Error("Invalid policy should fail")
+	}
+
+	// The hook can cancel the reboot
+	runner = newFakeRunner().on("sh -c /bin/check", "busy", fmt.Errorf("exit status 1"))
+	d.setRunner(runner)
+	d.Config.Reboot = RebootConfig{Policy: RebootImmediate, PreRebootHook: "/bin/check"}
+	if err := d.RebootContext(context.Background(), now, false); err == nil {
+		t.Error("A failed hook should cancel the reboot")
+	}
+	assertCalls(t, runner, "sh -c /bin/check")
+
+	runner = newFakeRunner().
+		on("sh -c /bin/check", "", nil).
+		on("docker-compose -f docker-compose.yml stop", "", nil).
+		on("systemctl reboot", "", nil)
+	d.setRunner(runner)
+	d.Config.Reboot = RebootConfig{Policy: RebootDelayed, Delay: "1ms", PreRebootHook: "/bin/check"}
+	if err := d.RebootContext(context.Background(), now, false); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner, "sh -c /bin/check", "docker-compose -f docker-compose.yml stop", "systemctl reboot")
+
+	// Forced reboots ignore the policy
+	runner = newFakeRunner().
+		on("docker-compose -f docker-compose.yml stop", "", nil).
+		on("/sbin/reboot -f", "", nil)
+	d.setRunner(runner)
+	d.Config.Reboot = RebootConfig{Policy: RebootNever, Command: []string{"/sbin/reboot", "-f"}}
+	if err := d.RebootContext(context.Background(), now, true); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, runner, "docker-compose -f docker-compose.yml stop", "/sbin/reboot -f")
+
+	// The containers are started again when the device can't reboot
+	runner = newFakeRunner().
+		on("docker-compose -f docker-compose.yml stop", "", nil).
+		on("systemctl reboot", "", fmt.Errorf("exit status 1")).
+		on("docker-compose -f docker-compose.yml up -d", "", nil)
+	d.setRunner(runner)
+	d.Config.Reboot = RebootConfig{Policy: RebootImmediate}
+	if err := d.RebootContext(context.Background(), now, false); err == nil {
+		t.Error("A failed reboot command should fail")
+	}
+	assertCalls(t, runner, "docker-compose -f docker-compose.yml stop", "systemctl reboot", "docker-compose -f docker-compose.yml up -d")
+}
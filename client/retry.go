@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how network operations are retried after transient
+// failures. Delays grow exponentially from BaseDelay up to MaxDelay and are
+// randomized by up to Jitter of their value so that a fleet of devices
+// doesn't retry in lock step.
+type RetryPolicy struct {
+	// Attempts per operation, including the first one
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  3,
+	BaseDelay: 2 * time.Second,
+	MaxDelay:  time.Minute,
+	Jitter:    0.2,
+}
+
+// Returns the retry policy configured for the device
+func (d *Device) RetryPolicy() (RetryPolicy, error) {
+	policy := DefaultRetryPolicy
+	cfg := d.Config.Retry
+	if cfg.Attempts > 0 {
+		policy.Attempts = cfg.Attempts
+	}
+	if cfg.Jitter > 0 {
+		policy.Jitter = cfg.Jitter
+	}
+	var err error
+	if len(cfg.BaseDelay) > 0 {
+		if policy.BaseDelay, err = time.ParseDuration(cfg.BaseDelay); err != nil {
+			return policy, fmt.Errorf("Invalid Retry.BaseDelay: %s", err)
+		}
+	}
+	if len(cfg.MaxDelay) > 0 {
+		if policy.MaxDelay, err = time.ParseDuration(cfg.MaxDelay); err != nil {
+			return policy, fmt.Errorf("Invalid Retry.MaxDelay: %s", err)
+		}
+	}
+	return policy, nil
+}
+
+// The delay before the given retry, the first being 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a context whose network operations are retried
+// according to p. Contexts without a policy use DefaultRetryPolicy.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+func retryPolicy(ctx context.Context) RetryPolicy {
+	if p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+// Reports whether an operation that failed with err may succeed if tried
+// again. Content and trust problems won't go away by retrying.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr *NetworkError
+	if errors.As(err, &netErr) {
+		code := netErr.StatusCode
+		if code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests {
+			return false
+		}
+		return true
+	}
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		// Killed when its phase timed out
+		if errors.Is(execErr.Err, context.DeadlineExceeded) {
+			return true
+		}
+		return transientOutput(execErr.Output)
+	}
+	return false
+}
+
+// Output of ostree and docker that points at a network problem. Commands
+// failing for any other reason, like a missing commit or a signature that
+// doesn't verify, fail the same way when run again.
+var transientMessages = []string{
+	"timed out",
+	"timeout",
+	"connection refused",
+	"connection reset",
+	"could not resolve",
+	"temporary failure in name resolution",
+	"network is unreachable",
+	"no route to host",
+	"tls handshake",
+	"unexpected eof",
+	"service unavailable",
+	"bad gateway",
+	"too many requests",
+}
+
+func transientOutput(output string) bool {
+	output = strings.ToLower(output)
+	for _, msg := range transientMessages {
+		if strings.Contains(output, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs op until it succeeds, fails with an error that isn't retryable, the
+// policy's attempts are exhausted or ctx is done
+func retry(ctx context.Context, what string, op func() error) error {
+	policy := retryPolicy(ctx)
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= policy.Attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		delay := policy.delay(attempt)
+		logrus.Warnf("%s failed (attempt %d of %d), retrying in %s: %s",
+			what, attempt, policy.Attempts, delay.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
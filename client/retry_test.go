@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for idx, delay := range expected {
+		if d := p.delay(idx + 1); d != delay {
+			t.Errorf("Invalid delay for retry %d: %s != %s", idx+1, d, delay)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if d := p.delay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Errorf("Jitter out of range: %s", d)
+		}
+	}
+}
+
+func TestDeviceRetryPolicy(t *testing.T) {
+	d := Device{Config: DeviceConfig{Retry: RetryConfig{Attempts: 7, MaxDelay: "10s"}}}
+	p, err := d.RetryPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Attempts != 7 || p.MaxDelay != 10*time.Second || p.BaseDelay != DefaultRetryPolicy.BaseDelay {
+		t.Errorf("Invalid policy: %v", p)
+	}
+	d.Config.Retry.BaseDelay = "soon"
+	if _, err := d.RetryPolicy(); err == nil {
+		t.Error("Invalid delays should fail")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&NetworkError{Url: "x", Err: fmt.Errorf("connection refused")}, true},
+		{&NetworkError{Url: "x", Err: fmt.Errorf("HTTP_503"), StatusCode: 503}, true},
+		{&NetworkError{Url: "x", Err: fmt.Errorf("HTTP_429"), StatusCode: 429}, true},
+		{&NetworkError{Url: "x", Err: fmt.Errorf("HTTP_404"), StatusCode: 404}, false},
+		{&NetworkError{Url: "x", Err: context.Canceled}, false},
+		{&ExecError{Args: []string{"ostree", "pull"}, Err: fmt.Errorf("exit status 1"), Output: "Connection timed out"}, true},
+		{&ExecError{Args: []string{"docker", "pull"}, Err: fmt.Errorf("exit status 1"), Output: "net/http: TLS handshake timeout"}, true},
+		{&ExecError{Args: []string{"ostree", "pull"}, Err: context.DeadlineExceeded}, true},
+		{&ExecError{Args: []string{"ostree", "pull"}, Err: fmt.Errorf("exit status 1")}, false},
+		{&ExecError{Args: []string{"ostree", "pull"}, Err: fmt.Errorf("exit status 1"), Output: "No such metadata object aa.commit"}, false},
+		{&ExecError{Args: []string{"docker", "pull"}, Err: fmt.Errorf("exit status 1"), Output: "Error: remote trust data does not exist"}, false},
+		{&HashMismatchError{"x", "aa", "bb"}, false},
+		{&TrustError{"x", fmt.Errorf("bad signature")}, false},
+		{fmt.Errorf("something else"), false},
+	}
+	for _, test := range tests {
+		if retryable(test.err) != test.retryable {
+			t.Errorf("retryable(%s) != %v", test.err, test.retryable)
+		}
+	}
+}
+
+func TestRetryOSTreePull(t *testing.T) {
+	ctx := WithRetryPolicy(context.Background(), RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	runner := newFakeRunner().
+		on("ostree pull tuftree aa", "Connection timed out", fmt.Errorf("exit status 1")).
+		on("ostree pull tuftree aa", "", nil).
+		on("ostree admin deploy aa", "", nil)
+	if err := OSTreeUpdateContext(ctx, runner, "tuftree", "aa"); err != nil {
+		t.Fatalf("Pull should succeed on retry: %s", err)
+	}
+	assertCalls(t, runner, "ostree show aa", "ostree pull tuftree aa", "ostree pull tuftree aa", "ostree admin deploy aa")
+
+	runner = newFakeRunner().on("ostree pull tuftree aa", "Could not resolve hostname", fmt.Errorf("exit status 1"))
+	if err := OSTreeUpdateContext(ctx, runner, "tuftree", "aa"); err == nil {
+		t.Fatal("Pull should fail once attempts are exhausted")
+	}
+	assertCalls(t, runner, "ostree show aa", "ostree pull tuftree aa", "ostree pull tuftree aa", "ostree pull tuftree aa")
+
+	// A commit that doesn't verify won't verify on the next attempt either
+	runner = newFakeRunner().on("ostree pull tuftree aa", "GPG signatures found, but none are in trusted keyring", fmt.Errorf("exit status 1"))
+	if err := OSTreeUpdateContext(ctx, runner, "tuftree", "aa"); err == nil {
+		t.Fatal("Untrusted commit should fail")
+	}
+	assertCalls(t, runner, "ostree show aa", "ostree pull tuftree aa")
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/hex"
+
+	"github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+// The name of the target listing the revoked targets of a collection
+const BlocklistTarget = "blocklist"
+
+// BlocklistCustom is the custom data of a collection's blocklist target.
+// Its content is the signed metadata itself, there's nothing to download.
+type BlocklistCustom struct {
+	TUFCustom
+
+	// Revoked target names
+	Names []string `json:"names,omitempty"`
+	// Revoked sha256 target hashes, hex encoded
+	Hashes []string `json:"hashes,omitempty"`
+}
+
+// Revocations are the targets of a collection that must not be installed,
+// either flagged "revoked" in their own custom data or listed by the
+// blocklist target
+type Revocations struct {
+	names  map[string]bool
+	hashes map[string]bool
+}
+
+// Collects the revocations from a collection's signed targets
+func NewRevocations(targets []*client.TargetWithRole) *Revocations {
+	r := Revocations{names: make(map[string]bool), hashes: make(map[string]bool)}
+	for _, target := range targets {
+		if target.Name == BlocklistTarget {
+			blocklist := BlocklistCustom{}
+			if target.Custom != nil {
+				if err := json.Unmarshal(*target.Custom, &blocklist); err != nil {
+					logrus.Warnf("Ignoring invalid blocklist: %s", err)
+					continue
+				}
+			}
+			for _, name := range blocklist.Names {
+				r.names[name] = true
+			}
+			for _, hash := range blocklist.Hashes {
+				r.hashes[hash] = true
+			}
+			continue
+		}
+		custom, err := tufCustom(target)
+		if err == nil && custom.Revoked {
+			r.names[target.Name] = true
+		}
+	}
+	return &r
+}
+
+// Reports whether a target, e.g. the one installed, has been revoked
+func (r *Revocations) Revoked(target *client.TargetWithRole) bool {
+	return r.names[target.Name] || r.hashes[hex.EncodeToString(target.Hashes["sha256"])]
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/theupdateframework/notary/client"
+)
+
+func TestRevocations(t *testing.T) {
+	targets := []*client.TargetWithRole{
+		newTestTarget("v4-intel", "dd", `{"targetFormat": "OSTREE", "ostree": "x", "revoked": true}`),
+		newTestTarget("v3-intel", "cc", `{"targetFormat": "OSTREE", "ostree": "x"}`),
+		newTestTarget("v2-intel", "bb", `{"targetFormat": "OSTREE", "ostree": "x"}`),
+		newTestTarget("v1-intel", "aa", `{"targetFormat": "OSTREE", "ostree": "x"}`),
+		newTestTarget(BlocklistTarget, "00", `{"targetFormat": "BLOCKLIST", "names": ["v3-intel"], "hashes": ["bb"]}`),
+	}
+	revoked := NewRevocations(targets)
+	for idx, expected := range []bool{true, true, true, false} {
+		if revoked.Revoked(targets[idx]) != expected {
+			t.Errorf("%s revoked != %v", targets[idx].Name, expected)
+		}
+	}
+	// The installed copy of a target is revoked by the signed list
+	if !revoked.Revoked(baseTestTarget("v4-intel", "dd", "")) {
+		t.Error("Installed targets should be checked against the signed list")
+	}
+
+	d := Device{}
+	if names := targetNames(d.AvailableTargets(targets)); names != "v1-intel" {
+		t.Errorf("Revoked targets should not be available: %s", names)
+	}
+
+	if _, err := rollbackTarget(targets, nil, nil, "cc", false); err == nil {
+		t.Error("Rollback to a revoked target should be refused")
+	}
+	if tgt, err := rollbackTarget(targets, nil, nil, "cc", true); err != nil || tgt != targets[1] {
+		t.Errorf("Forced rollback to a revoked target should work: %v %v", tgt, err)
+	}
+}
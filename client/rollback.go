@@ -0,0 +1,116 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+)
+
+const rollbackTrigger = "rollback"
+
+// Finds the target to roll back to. The copy from the signed targets list is
+// preferred. If it has been removed from the list, the copy recorded in the
+// history is only used when forced.
+func rollbackTarget(signed []*client.TargetWithRole, listErr error, entry *HistoryEntry, hash string, force bool) (*client.TargetWithRole, error) {
+	revoked := NewRevocations(signed)
+	for _, target := range signed {
+		if hex.EncodeToString(target.Hashes["sha256"]) != hash {
+			continue
+		}
+		if revoked.Revoked(target) {
+			if !force {
+				return nil, fmt.Errorf("Previous target %s has been revoked, use force to roll back anyway", target.Name)
+			}
+			logrus.Warnf("Rolling back to %s which has been revoked", target.Name)
+		}
+		return target, nil
+	}
+	if !force {
+		if listErr != nil {
+			return nil, fmt.Errorf("Unable to check previous target(%s) against the signed targets list: %s", hash, listErr)
+		}
+		return nil, fmt.Errorf("Previous target(%s) is no longer in the signed targets list, use force to roll back anyway", hash)
+	}
+	if entry == nil || entry.Target == nil {
+		return nil, fmt.Errorf("No record of previous target(%s) in the update history", hash)
+	}
+	logrus.Warnf("Rolling back to %s which is not in the signed targets list", entry.Target.Name)
+	if revoked.Revoked(entry.Target) {
+		logrus.Warnf("%s has been revoked", entry.Target.Name)
+	}
+	return entry.Target, nil
+}
+
+// RollbackBase deploys the previous OSTree deployment. Its target from the
+// update history becomes the current base once FinalizeBase runs after the
+// reboot.
+func (d *Device) RollbackBase(force bool) error {
+	if d.OSTreeStatus.Rollback == nil {
+		return fmt.Errorf("Device has no previous OSTree deployment")
+	}
+	hash := *d.OSTreeStatus.Rollback
+
+	history := d.History()
+	entry, err := history.lastInstall(HistoryBase, "", hash)
+	if err != nil {
+		return err
+	}
+	var signed []*client.TargetWithRole
+	var listErr error
+	if d.BaseNotary != nil {
+		signed, listErr = d.BaseTargets()
+	} else {
+		listErr = fmt.Errorf("Device is not configured for base updates")
+	}
+	target, err := rollbackTarget(signed, listErr, entry, hash, force)
+	if err != nil {
+		return err
+	}
+
+	from, _, _ := d.BaseTarget()
+	record := newHistoryEntry(HistoryBase, from, target, rollbackTrigger)
+	logrus.Infof("Rolling back base to %s, ostree hash %s", target.Name, hash)
+	err = OSTreeDeploy(d.Runner, hash)
+	if err == nil {
+		err = saveTarget(d.pendingBaseFile(), target)
+	}
+	history.record(record, err)
+	return err
+}
+
+// RollbackPersonality reinstalls the personality target that was running
+// before the current one
+func (d *Device) RollbackPersonality(p *Personality, force bool) error {
+	cur, _, err := p.Target()
+	if err != nil {
+		return err
+	}
+	curHash := hex.EncodeToString(cur.Hashes["sha256"])
+
+	history := d.History()
+	entry, err := history.lastInstall(HistoryPersonality, p.Name(), curHash)
+	if err != nil {
+		return err
+	}
+	if entry == nil || len(entry.FromHash) == 0 {
+		return fmt.Errorf("No previous target for personality(%s) in the update history", p.Name())
+	}
+	prev, err := history.lastInstall(HistoryPersonality, p.Name(), entry.FromHash)
+	if err != nil {
+		return err
+	}
+	signed, listErr := p.Targets()
+	target, err := rollbackTarget(signed, listErr, prev, entry.FromHash, force)
+	if err != nil {
+		return err
+	}
+
+	record := newHistoryEntry(HistoryPersonality, cur, target, rollbackTrigger)
+	record.Personality = p.Name()
+	logrus.Infof("Rolling back personality(%s) to %s", p.Name(), target.Name)
+	err = p.Update(target)
+	history.record(record, err)
+	return err
+}
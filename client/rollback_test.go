@@ -0,0 +1,75 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/theupdateframework/notary/client"
+)
+
+func TestRollbackTarget(t *testing.T) {
+	signed := []*client.TargetWithRole{baseTestTarget("v2-intel", "bb", "")}
+	entry := &HistoryEntry{Target: baseTestTarget("v1-intel", "aa", "")}
+
+	tgt, err := rollbackTarget(signed, nil, entry, "bb", false)
+	if err != nil || tgt != signed[0] {
+		t.Errorf("Signed target should be used: %v %s", tgt, err)
+	}
+	if _, err = rollbackTarget(signed, nil, entry, "aa", false); err == nil {
+		t.Error("Rollback to an unsigned target should be refused")
+	}
+	tgt, err = rollbackTarget(signed, nil, entry, "aa", true)
+	if err != nil || tgt != entry.Target {
+		t.Errorf("Forced rollback should use history: %v %s", tgt, err)
+	}
+	if _, err = rollbackTarget(nil, fmt.Errorf("offline"), nil, "aa", true); err == nil {
+		t.Error("Rollback without a record of the target should fail")
+	}
+}
+
+func TestRollbackBase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollback-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, _ := newPlanDevice(t, dir)
+
+	if err := d.RollbackBase(false); err == nil {
+		t.Error("Rollback should fail without a previous deployment")
+	}
+
+	prev := "cc"
+	d.OSTreeStatus.Rollback = &prev
+	d.History().record(newHistoryEntry(HistoryBase, nil, baseTestTarget("v0-intel", "cc", ""), "manual"), nil)
+
+	runner := newFakeRunner().on("ostree admin deploy cc", "", nil)
+	d.setRunner(runner)
+
+	// Not configured for base updates, so the signed list can't be checked
+	if err := d.RollbackBase(false); err == nil {
+		t.Error("Unforced rollback should fail when the target can't be checked")
+	}
+	if err := d.RollbackBase(true); err != nil {
+		t.Fatalf("Forced rollback failed: %s", err)
+	}
+	assertCalls(t, runner, "ostree admin deploy cc")
+	d.OSTreeStatus.Active = "cc"
+	if err := d.FinalizeBase(); err != nil {
+		t.Fatal(err)
+	}
+	tgt, _, err := d.BaseTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgt.Name != "v0-intel" {
+		t.Errorf("base.json not restored: %s", tgt.Name)
+	}
+	entries, _ := d.History().Entries()
+	last := entries[len(entries)-1]
+	if last.Trigger != rollbackTrigger || last.FromTarget != "v1-intel" || last.Result != HistorySuccess {
+		t.Errorf("Rollback not recorded in history: %v", last)
+	}
+}
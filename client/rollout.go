@@ -0,0 +1,75 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Generates a random device ID for rollouts
+func newDeviceId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// EnsureDeviceId generates and saves an ID for devices initialized before
+// rollouts existed. It changes config.json, so callers must hold the config
+// dir's lock. Until then such devices are only part of complete rollouts.
+func (d *Device) EnsureDeviceId() error {
+	if len(d.Config.DeviceId) > 0 {
+		return nil
+	}
+	id, err := newDeviceId()
+	if err != nil {
+		return fmt.Errorf("Unable to generate a device ID: %s", err)
+	}
+	newConfig := d.Config
+	newConfig.DeviceId = id
+	if err := saveConfig(path.Join(d.configDir, "config.json"), newConfig); err != nil {
+		return err
+	}
+	d.Config = newConfig
+	logrus.Infof("Generated device ID %s", id)
+	return nil
+}
+
+// Places a device in [0, 100) so that a rollout to N% includes the devices
+// below N. A device keeps its place while the salt doesn't change, so it
+// stays included as the percentage grows.
+func rolloutPosition(salt, deviceId string) float64 {
+	sum := sha256.Sum256([]byte(salt + ":" + deviceId))
+	return float64(binary.BigEndian.Uint64(sum[:8])%10000) / 100
+}
+
+// Includes reports whether a device is part of the rollout. Every device is
+// part of a nil one. Devices without an ID are only included once a rollout
+// reaches 100%.
+func (r *Rollout) Includes(deviceId string) bool {
+	if r == nil || r.Percentage >= 100 {
+		return true
+	}
+	if len(deviceId) == 0 {
+		return false
+	}
+	for _, id := range r.Devices {
+		if id == deviceId {
+			return true
+		}
+	}
+	return rolloutPosition(r.Salt, deviceId) < r.Percentage
+}
+
+func (r *Rollout) validate() error {
+	if r != nil && (r.Percentage < 0 || r.Percentage > 100) {
+		return fmt.Errorf("percentage %v is not between 0 and 100", r.Percentage)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/theupdateframework/notary/client"
+)
+
+func TestRolloutIncludes(t *testing.T) {
+	var none *Rollout
+	if !none.Includes("") {
+		t.Error("Targets without a rollout should be available to every device")
+	}
+	allowed := &Rollout{Devices: []string{"lab-1"}}
+	if !allowed.Includes("lab-1") || allowed.Includes("lab-2") || allowed.Includes("") {
+		t.Error("Only listed devices should be included at 0%")
+	}
+
+	// The expected fraction of devices is included and growing the
+	// percentage never drops any of them
+	small := &Rollout{Percentage: 5, Salt: "v42"}
+	large := &Rollout{Percentage: 25, Salt: "v42"}
+	included := 0
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("device-%d", i)
+		if small.Includes(id) {
+			included++
+			if !large.Includes(id) {
+				t.Fatalf("%s dropped when the rollout grew", id)
+			}
+		}
+	}
+	if included < 400 || included > 600 {
+		t.Errorf("%d of 10000 devices included in a 5%% rollout", included)
+	}
+
+	if err := (&Rollout{Percentage: 101}).validate(); err == nil {
+		t.Error("Percentages over 100 should fail")
+	}
+}
+
+func TestAvailableTargetsRollout(t *testing.T) {
+	targets := []*client.TargetWithRole{
+		newTestTarget("v2-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x", "rollout": {"percentage": 0, "devices": ["lab-1"]}}`),
+		newTestTarget("v1-intel", "00", `{"targetFormat": "OSTREE", "ostree": "x"}`),
+	}
+	d := Device{Config: DeviceConfig{DeviceId: "lab-2"}}
+	if names := targetNames(d.AvailableTargets(targets)); names != "v1-intel" {
+		t.Errorf("Unexpected targets outside the rollout: %s", names)
+	}
+	d.Config.DeviceId = "lab-1"
+	if names := targetNames(d.AvailableTargets(targets)); names != "v2-intel,v1-intel" {
+		t.Errorf("Unexpected targets in the rollout: %s", names)
+	}
+}
+
+func TestDeviceIdGenerated(t *testing.T) {
+	dir := t.TempDir()
+	if err := saveConfig(path.Join(dir, "config.json"), DeviceConfig{HardwareId: "intel"}); err != nil {
+		t.Fatal(err)
+	}
+	runner := newFakeRunner().on("ostree admin status", "* lmp aa.0\n", nil)
+	d, err := NewDeviceWithRunner(dir, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Config.DeviceId) != 0 {
+		t.Fatalf("Loading a device must not change its config: %s", d.Config.DeviceId)
+	}
+	if err := d.EnsureDeviceId(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Config.DeviceId) != 32 {
+		t.Fatalf("Invalid device ID: %s", d.Config.DeviceId)
+	}
+	again, err := NewDeviceWithRunner(dir, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Config.DeviceId != d.Config.DeviceId {
+		t.Errorf("Device ID not persisted: %s != %s", again.Config.DeviceId, d.Config.DeviceId)
+	}
+	if err := again.EnsureDeviceId(); err != nil || again.Config.DeviceId != d.Config.DeviceId {
+		t.Errorf("Existing device ID must be kept: %s %v", again.Config.DeviceId, err)
+	}
+}
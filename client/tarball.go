@@ -2,7 +2,6 @@ package client
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
@@ -21,7 +20,7 @@ func mkdir(header *tar.Header, dst string) error {
 	return nil
 }
 
-func mkfile(tr *tar.Reader, header *tar.Header, dst string) error {
+func mkfile(tr archiveReader, header *tar.Header, dst string) error {
 	path := path.Join(dst, header.Name)
 	fd, err := os.Create(path)
 	defer fd.Close()
@@ -55,18 +54,45 @@ func mksymlink(header *tar.Header, dst string) error {
 	return nil
 }
 
-func extract(tr *tar.Reader, dst string, stripLeading bool) error {
+// Ensures an archive entry can't write outside of the destination directory
+func withinDir(dst, target string) bool {
+	dst = path.Clean(dst)
+	target = path.Clean(target)
+	return target == dst || strings.HasPrefix(target, dst+"/")
+}
+
+func checkEntry(header *tar.Header, dst string) error {
+	to := path.Join(dst, header.Name)
+	if !withinDir(dst, to) {
+		return fmt.Errorf("Archive entry(%s) is outside of the extraction directory", header.Name)
+	}
+	if header.Typeflag == tar.TypeLink || header.Typeflag == tar.TypeSymlink {
+		from := path.Join(path.Dir(to), header.Linkname)
+		if !withinDir(dst, from) {
+			return fmt.Errorf("Archive link(%s -> %s) is outside of the extraction directory", header.Name, header.Linkname)
+		}
+	}
+	return nil
+}
+
+func extract(tr archiveReader, dst string, stripLeading bool) error {
 	for true {
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return fmt.Errorf("Unable to read archive: %s", err)
+		}
 		if stripLeading {
 			idx := strings.Index(header.Name, "/")
 			if idx > 0 {
 				header.Name = header.Name[idx+1:]
 			}
 		}
+		if err := checkEntry(header, dst); err != nil {
+			return err
+		}
 		switch header.Typeflag {
 		case tar.TypeReg:
 			if err := mkfile(tr, header, dst); err != nil {
@@ -93,15 +119,10 @@ func extract(tr *tar.Reader, dst string, stripLeading bool) error {
 	return nil
 }
 
-func extractFile(fileName string, dst string, stripLeading bool) error {
-	fd, err := os.Open(fileName)
-	if err != nil {
-		return err
-	}
-	gzf, err := gzip.NewReader(fd)
+func extractFile(fileName string, dst string, format string, stripLeading bool) error {
+	tr, err := openArchive(fileName, format)
 	if err != nil {
 		return err
 	}
-	tr := tar.NewReader(gzf)
 	return extract(tr, dst, stripLeading)
 }
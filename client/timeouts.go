@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts bound the phases of an update. A zero value means the phase is
+// only bounded by the context it runs in.
+type Timeouts struct {
+	// Fetching TUF metadata from a notary server
+	Metadata time.Duration
+	// Downloading personality archives
+	Download time.Duration
+	// ostree pull and docker pull
+	Pull time.Duration
+	// Stopping and starting docker-compose projects
+	Compose time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Metadata: 2 * time.Minute,
+	Download: 30 * time.Minute,
+	Pull:     2 * time.Hour,
+	Compose:  30 * time.Minute,
+}
+
+type timeoutsKey struct{}
+
+// WithTimeouts returns a context whose update phases are bounded by t.
+// Contexts without timeouts use DefaultTimeouts.
+func WithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, t)
+}
+
+func timeouts(ctx context.Context) Timeouts {
+	if t, ok := ctx.Value(timeoutsKey{}).(Timeouts); ok {
+		return t
+	}
+	return DefaultTimeouts
+}
+
+// Derives the context for a phase of an update. The phase function picks
+// its timeout from the Timeouts of ctx.
+func phaseContext(ctx context.Context, phase func(Timeouts) time.Duration) (context.Context, context.CancelFunc) {
+	if timeout := phase(timeouts(ctx)); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func metadataPhase(t Timeouts) time.Duration { return t.Metadata }
+func downloadPhase(t Timeouts) time.Duration { return t.Download }
+func pullPhase(t Timeouts) time.Duration     { return t.Pull }
+func composePhase(t Timeouts) time.Duration  { return t.Compose }
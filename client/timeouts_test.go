@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPhaseContext(t *testing.T) {
+	ctx := WithTimeouts(context.Background(), Timeouts{Download: time.Minute})
+	dctx, cancel := phaseContext(ctx, downloadPhase)
+	defer cancel()
+	if deadline, ok := dctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("Download phase should have a 1m deadline: %v", deadline)
+	}
+	pctx, cancel := phaseContext(ctx, pullPhase)
+	defer cancel()
+	if _, ok := pctx.Deadline(); ok {
+		t.Error("Pull phase should have no deadline")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	done := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ctx := WithTimeouts(context.Background(), Timeouts{Download: 100 * time.Millisecond})
+	ctx = WithRetryPolicy(ctx, RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	dcc := DockerComposeCustom{TgzUrl: server.URL}
+	err := fetchArchive(ctx, &NotaryClient{}, path.Join(t.TempDir(), "x.tgz"), "deadbeef", dcc)
+	var netErr *NetworkError
+	if !errors.As(err, &netErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timed out NetworkError: %v", err)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Errorf("A timed out download should be retried: %d requests", requests)
+	}
+}
@@ -1,16 +1,15 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 	"time"
 
-	"github.com/docker/distribution/registry/client/auth"
-	"github.com/docker/distribution/registry/client/auth/challenge"
-	"github.com/docker/distribution/registry/client/transport"
-	"github.com/docker/go-connections/tlsconfig"
 	"github.com/docker/go/canonical/json"
 	"github.com/sirupsen/logrus"
 	"github.com/theupdateframework/notary/client"
@@ -18,21 +17,109 @@ import (
 	"github.com/theupdateframework/notary/tuf/data"
 )
 
+func newNotaryClient(trustDir, serverURL string, h httpClients, allowedRoles []string) (*NotaryClient, error) {
+	c := NotaryClient{trustDir: trustDir, serverURL: serverURL, http: h}
+	for _, role := range allowedRoles {
+		name := data.RoleName(role)
+		if name != data.CanonicalTargetsRole && !data.IsDelegation(name) {
+			return nil, fmt.Errorf("Invalid role '%s', must be targets or a delegation of it", role)
+		}
+		c.roles = append(c.roles, name)
+	}
+	return &c, nil
+}
+
 func sortTargets(targets []*client.TargetWithRole) {
 	sort.Slice(targets, func(i, j int) bool {
 		return targets[i].Name > targets[j].Name
 	})
 }
 
+// TargetList is the result of listing a collection's targets
+type TargetList struct {
+	// Sorted with the newest name first
+	Targets []*client.TargetWithRole
+	// Set when the notary server couldn't be reached and the targets come from
+	// the metadata cached in the trust dir. Its signatures and expiry are still
+	// enforced, but newer targets may exist.
+	Offline bool
+	// Why the server couldn't be reached
+	OfflineReason error
+	// When the cached metadata was last refreshed from the server
+	CachedAt time.Time
+}
+
 func (c NotaryClient) Targets(image string) ([]*client.TargetWithRole, error) {
-	gun := data.GUN(image)
-	transport, err := c.getTransport(gun)
+	return c.TargetsContext(context.Background(), image)
+}
+
+// TargetsContext lists the targets of a collection. The notary requests are
+// aborted once ctx is done or the metadata timeout expires. Transient
+// failures are retried. See ListTargets for the offline fallback.
+func (c NotaryClient) TargetsContext(ctx context.Context, image string) ([]*client.TargetWithRole, error) {
+	list, err := c.ListTargets(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	return list.Targets, nil
+}
+
+// ListTargets lists the targets of a collection like TargetsContext. When
+// the notary server can't be reached, the cached metadata is used instead
+// and the list is marked as offline.
+func (c NotaryClient) ListTargets(ctx context.Context, image string) (*TargetList, error) {
+	var targets []*client.TargetWithRole
+	err := retry(ctx, "Fetching TUF metadata for "+image, func() error {
+		var err error
+		targets, err = c.targets(ctx, image)
+		return err
+	})
+	if err == nil {
+		return &TargetList{Targets: targets}, nil
+	}
+	var netErr *NetworkError
+	if !errors.As(err, &netErr) || ctx.Err() != nil {
+		return nil, err
+	}
+	list, cacheErr := c.cachedTargets(ctx, image)
+	if cacheErr != nil {
+		logrus.Debugf("No usable cached TUF metadata for %s: %s", image, cacheErr)
+		return nil, err
+	}
+	list.OfflineReason = err
+	logrus.Warnf("Using TUF metadata for %s cached %s, %s", image, list.CachedAt.Format(time.RFC3339), err)
+	return list, nil
+}
+
+func (c NotaryClient) targets(ctx context.Context, image string) ([]*client.TargetWithRole, error) {
+	ctx, cancel := phaseContext(ctx, metadataPhase)
+	defer cancel()
+	transport, err := c.getTransport(ctx, data.GUN(image))
+	if err != nil {
+		return nil, err
+	}
+	return c.listTargets(ctx, image, transport)
+}
+
+// Lists the targets from the trust dir's cache alone
+func (c NotaryClient) cachedTargets(ctx context.Context, image string) (*TargetList, error) {
+	// Notary only updates it after validating what the server sent
+	st, err := os.Stat(filepath.Join(c.trustDir, "tuf", filepath.FromSlash(image), "metadata", "timestamp.json"))
+	if err != nil {
+		return nil, err
+	}
+	// Without a transport notary reads the cache and nothing else
+	targets, err := c.listTargets(ctx, image, nil)
 	if err != nil {
-		logrus.Panicf("Unable to create notary transport: %s", err)
+		return nil, err
 	}
+	return &TargetList{Targets: targets, Offline: true, CachedAt: st.ModTime()}, nil
+}
+
+func (c NotaryClient) listTargets(ctx context.Context, image string, transport http.RoundTripper) ([]*client.TargetWithRole, error) {
 	repo, err := client.NewFileCachedRepository(
 		c.trustDir,
-		gun,
+		data.GUN(image),
 		c.serverURL,
 		transport,
 		nil,
@@ -42,63 +129,39 @@ func (c NotaryClient) Targets(image string) ([]*client.TargetWithRole, error) {
 		return nil, fmt.Errorf("Unable to create notary cache for %s: %s", image, err.Error())
 	}
 
-	targets, err := repo.ListTargets()
+	targets, err := repo.ListTargets(c.roles...)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to list targets for %s: %s", image, err.Error())
+		if ctx.Err() != nil {
+			return nil, &NetworkError{Url: c.serverURL, Err: ctx.Err()}
+		}
+		return nil, notaryError(image, c.serverURL, err)
 	}
 
+	targets = c.allowedTargets(targets)
 	sortTargets(targets)
 	return targets, nil
 }
 
-func (c NotaryClient) getTransport(gun data.GUN) (http.RoundTripper, error) {
-	tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
-		CAFile:             c.rootCAFile,
-		ExclusiveRootPools: true,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable to configure TLS: %s", err.Error())
-	}
-
-	base := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).Dial,
-		TLSHandshakeTimeout: 10 * time.Second,
-		TLSClientConfig:     tlsConfig,
-		DisableKeepAlives:   true,
-	}
-
-	modifiers := []transport.RequestModifier{
-		transport.NewHeaderRequestModifier(http.Header{
-			"User-Agent": []string{"tuftree"},
-		}),
-	}
-	authTransport := transport.NewTransport(base, modifiers...)
-	pingClient := &http.Client{
-		Transport: authTransport,
-		Timeout:   5 * time.Second,
+// Drops the targets signed by roles other than the allowed ones. Notary
+// includes those of the delegations below an allowed role.
+func (c NotaryClient) allowedTargets(targets []*client.TargetWithRole) []*client.TargetWithRole {
+	if len(c.roles) == 0 {
+		return targets
 	}
-	req, err := http.NewRequest("GET", c.serverURL+"/v2/", nil)
-	if err != nil {
-		panic(err)
+	var allowed []*client.TargetWithRole
+	for _, target := range targets {
+		for _, role := range c.roles {
+			if target.Role == role {
+				allowed = append(allowed, target)
+				break
+			}
+		}
 	}
+	return allowed
+}
 
-	challengeManager := challenge.NewSimpleManager()
-	resp, err := pingClient.Do(req)
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
-	if err := challengeManager.AddResponse(resp); err != nil {
-		panic(err)
-	}
-	tokenHandler := auth.NewTokenHandler(base, nil, gun.String(), "pull")
-	modifiers = append(modifiers, auth.NewAuthorizer(challengeManager, tokenHandler, auth.NewBasicHandler(nil)))
-	return transport.NewTransport(base, modifiers...), nil
+func (c NotaryClient) getTransport(ctx context.Context, gun data.GUN) (http.RoundTripper, error) {
+	return c.http.registryTransport(ctx, c.serverURL, gun.String())
 }
 
 func (c NotaryClient) OSTree(custom *json.RawMessage) (*OSTreeCustom, error) {
@@ -114,6 +177,12 @@ func (c NotaryClient) OSTree(custom *json.RawMessage) (*OSTreeCustom, error) {
 		if len(otc.Url) == 0 {
 			return nil, fmt.Errorf("Unable to parse OSTREE data, missing required filed 'ostree'")
 		}
+		if err := validateVersionRange(otc.PersonalityVersions); err != nil {
+			return nil, fmt.Errorf("Invalid OSTREE personalityVersions: %s", err)
+		}
+		if err := otc.Rollout.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid OSTREE rollout: %s", err)
+		}
 	}
 	return &otc, nil
 }
@@ -128,8 +197,22 @@ func (c NotaryClient) DockerCompose(custom *json.RawMessage) (*DockerComposeCust
 		if dcc.TargetFormat != "DOCKER_COMPOSE" {
 			return nil, fmt.Errorf("Invalid targetFormat %s != DOCKER_COMPOSE", dcc.TargetFormat)
 		}
-		if len(dcc.TgzUrl) == 0 {
-			return nil, fmt.Errorf("Unable to parse DOCKER_COMPOSE data, missing required filed 'tgz'")
+		if len(dcc.TgzUrl) == 0 && len(dcc.OCIArtifact) == 0 {
+			return nil, fmt.Errorf("Unable to parse DOCKER_COMPOSE data, missing required filed 'tgz' or 'ociArtifact'")
+		}
+		if len(dcc.OCIArtifact) > 0 {
+			if _, err := parseOCIArtifact(dcc.OCIArtifact); err != nil {
+				return nil, fmt.Errorf("Invalid DOCKER_COMPOSE ociArtifact: %s", err)
+			}
+		}
+		if err := validateVersionRange(dcc.BaseVersions); err != nil {
+			return nil, fmt.Errorf("Invalid DOCKER_COMPOSE baseVersions: %s", err)
+		}
+		if err := dcc.Rollout.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid DOCKER_COMPOSE rollout: %s", err)
+		}
+		if dcc.ArchiveFormat, err = normalizeArchiveFormat(dcc.ArchiveFormat); err != nil {
+			return nil, fmt.Errorf("Invalid DOCKER_COMPOSE archiveFormat: %s", err)
 		}
 	}
 	return &dcc, nil
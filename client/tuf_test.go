@@ -1,9 +1,18 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/docker/go/canonical/json"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/testutils"
 )
 
 func TestBadOStreeCustom(t *testing.T) {
@@ -87,3 +96,155 @@ func TestGoodDockerComposeCustom(t *testing.T) {
 		t.Errorf("DOCKER_COMPOSE env[bam] %s != bang", c.ComposeEnv["bam"])
 	}
 }
+
+// Writes signed metadata with a v1 target into the trust dir's cache, as
+// if it had been fetched from a notary server earlier
+func cacheMetadata(t *testing.T, trustDir, image string, timestampExpires time.Time) {
+	gun := data.GUN(image)
+	repo, _, err := testutils.EmptyRepo(gun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": make([]byte, 32)}}
+	if _, err := repo.AddTargets(data.CanonicalTargetsRole, data.Files{"v1": meta}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := repo.SignRoot(data.DefaultExpires(data.CanonicalRootRole), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := repo.SignTargets(data.CanonicalTargetsRole, data.DefaultExpires(data.CanonicalTargetsRole))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := repo.SignSnapshot(data.DefaultExpires(data.CanonicalSnapshotRole))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp, err := repo.SignTimestamp(timestampExpires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metaDir := filepath.Join(trustDir, "tuf", image, "metadata")
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	roles := map[data.RoleName]*data.Signed{
+		data.CanonicalRootRole:      root,
+		data.CanonicalTargetsRole:   targets,
+		data.CanonicalSnapshotRole:  snapshot,
+		data.CanonicalTimestampRole: timestamp,
+	}
+	for role, signed := range roles {
+		buf, err := json.Marshal(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(metaDir, role.String()+".json"), buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListTargetsOffline(t *testing.T) {
+	trustDir, err := ioutil.TempDir("", "tuftree-trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(trustDir)
+
+	// Nothing listens on port 1
+	notary := NotaryClient{trustDir: trustDir, serverURL: "http://127.0.0.1:1"}
+	ctx := WithRetryPolicy(context.Background(), RetryPolicy{Attempts: 1})
+
+	var netErr *NetworkError
+	if _, err := notary.ListTargets(ctx, "example.com/base"); !errors.As(err, &netErr) {
+		t.Fatalf("Expected a network error without cached metadata: %v", err)
+	}
+
+	cacheMetadata(t, trustDir, "example.com/base", data.DefaultExpires(data.CanonicalTimestampRole))
+	list, err := notary.ListTargets(ctx, "example.com/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Offline || !errors.As(list.OfflineReason, &netErr) || list.CachedAt.IsZero() {
+		t.Errorf("List not marked offline: %+v", list)
+	}
+	if len(list.Targets) != 1 || list.Targets[0].Name != "v1" {
+		t.Errorf("Unexpected cached targets: %+v", list.Targets)
+	}
+
+	cacheMetadata(t, trustDir, "example.com/expired", time.Now().Add(-time.Hour))
+	if _, err := notary.ListTargets(ctx, "example.com/expired"); !errors.As(err, &netErr) {
+		t.Errorf("Expired cached metadata should not be used: %v", err)
+	}
+}
+
+func TestMetadataExpiry(t *testing.T) {
+	trustDir, err := ioutil.TempDir("", "tuftree-trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(trustDir)
+
+	notary := &NotaryClient{trustDir: trustDir}
+	d := Device{Config: DeviceConfig{BaseCollectionName: "example.com/base"}, BaseNotary: notary}
+	if expiries, err := d.MetadataExpiry(time.Hour); err != nil || len(expiries) != 0 {
+		t.Fatalf("Nothing should be cached yet: %v %v", expiries, err)
+	}
+
+	soon := time.Now().Add(30 * time.Minute).UTC().Round(time.Second)
+	cacheMetadata(t, trustDir, "example.com/base", soon)
+	expiries, err := d.MetadataExpiry(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expiries) != 4 {
+		t.Fatalf("Expected the 4 top level roles: %v", expiries)
+	}
+	ts := expiries[0]
+	if ts.Role != "timestamp" || !ts.Expires.Equal(soon) || !ts.Expiring || ts.Expired {
+		t.Errorf("Invalid timestamp expiry: %+v", ts)
+	}
+	for _, r := range expiries[1:] {
+		if r.Expiring || r.Expired {
+			t.Errorf("%s should not be expiring: %+v", r.Role, r)
+		}
+	}
+
+	d.Config.MetadataExpiryWarning = "1d"
+	if _, err := d.ExpiryWarning(); err == nil {
+		t.Error("Invalid durations should fail")
+	}
+}
+
+func TestAllowedRoles(t *testing.T) {
+	if _, err := newNotaryClient("", "", httpClients{}, []string{"root"}); err == nil {
+		t.Error("Only targets and its delegations should be allowed")
+	}
+	if _, err := newPersonality("", DeviceConfig{}, PersonalityConfig{Name: "p", CollectionName: "c", AllowedRoles: []string{"releases"}}); err == nil {
+		t.Error("Personalities should validate their roles")
+	}
+
+	targets := []*client.TargetWithRole{
+		{Target: client.Target{Name: "v3"}, Role: "targets/ci"},
+		{Target: client.Target{Name: "v2"}, Role: "targets/releases"},
+		{Target: client.Target{Name: "v1"}, Role: "targets"},
+	}
+	c, err := newNotaryClient("", "", httpClients{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed := c.allowedTargets(targets); len(allowed) != 3 {
+		t.Errorf("Every role should be allowed by default: %v", allowed)
+	}
+	c, err = newNotaryClient("", "", httpClients{}, []string{"targets/releases", "targets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := c.allowedTargets(targets)
+	if len(allowed) != 2 || allowed[0].Name != "v2" || allowed[1].Name != "v1" {
+		t.Errorf("Unexpected allowed targets: %v", allowed)
+	}
+}
@@ -1,12 +1,19 @@
 package client
 
+import (
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
 type NotaryClient struct {
-	trustDir   string
-	serverURL  string
-	rootCAFile string
+	trustDir  string
+	serverURL string
+	http      httpClients
+	// Only targets signed by these roles are accepted, any when empty
+	roles []data.RoleName
 }
 
 type DockerComposeUpdater struct {
+	runner    Runner
 	cachedTgz string
 	dcc       DockerComposeCustom
 }
@@ -14,26 +21,109 @@ type DockerComposeUpdater struct {
 type TUFCustom struct {
 	TargetFormat string `json:"targetFormat"`
 	Uri          string `json:"uri"`
+	// Channels the target is released to, e.g. "stable" or "beta"
+	Tags []string `json:"tags,omitempty"`
+	// Limits the devices the target is available to
+	Rollout *Rollout `json:"rollout,omitempty"`
+	// Set when the target must no longer be installed
+	Revoked bool `json:"revoked,omitempty"`
+	// Installed by automatic updates outside maintenance windows
+	Urgent bool `json:"urgent,omitempty"`
+}
+
+// Rollout makes a target available to a subset of devices. A device is
+// eligible when its ID is listed in Devices or falls in the first
+// Percentage of devices, as ordered by a hash of Salt and its ID.
+type Rollout struct {
+	Percentage float64 `json:"percentage"`
+	// Changing it selects a different set of devices for the same percentage
+	Salt    string   `json:"salt,omitempty"`
+	Devices []string `json:"devices,omitempty"`
 }
 
 type OSTreeCustom struct {
 	TUFCustom
 
-	Url string `json:"ostree"`
+	Url                 string `json:"ostree"`
+	PersonalityVersions string `json:"personalityVersions,omitempty"`
 }
 
 type DockerComposeCustom struct {
 	TUFCustom
 
-	TgzUrl       string            `json:"tgz"`
-	TgzLeading   bool              `json:"tgzLeadingDir"`
-	ComposeFiles []string          `json:"compose-files,omitempty"`
-	ComposeEnv   map[string]string `json:"compose-env,omitempty"`
+	TgzUrl        string            `json:"tgz"`
+	OCIArtifact   string            `json:"ociArtifact,omitempty"`
+	TgzLeading    bool              `json:"tgzLeadingDir"`
+	ArchiveFormat string            `json:"archiveFormat,omitempty"`
+	ComposeFiles  []string          `json:"compose-files,omitempty"`
+	ComposeEnv    map[string]string `json:"compose-env,omitempty"`
+	BaseVersions  string            `json:"baseVersions,omitempty"`
 }
 
 type OSTreeStatus struct {
-	Active  string
-	Pending *string
+	Active   string
+	Pending  *string
+	Rollback *string
+}
+
+type PersonalityConfig struct {
+	Name            string
+	NotaryServerUrl string
+	NotaryCAFile    string
+	CollectionName  string
+	// Roles whose targets are accepted, e.g. "targets/releases". Any role is
+	// accepted when empty.
+	AllowedRoles []string `json:",omitempty"`
+}
+
+type RetentionConfig struct {
+	// Personality archives kept in the cache, including the current one
+	KeepArchives int `json:",omitempty"`
+	// Passed to "ostree prune --keep-younger-than", e.g. "30 days"
+	OSTreeKeepYoungerThan string `json:",omitempty"`
+	// Don't clean up after successful updates
+	DisableAutoCleanup bool `json:",omitempty"`
+}
+
+type RetryConfig struct {
+	// Attempts per network operation, including the first one
+	Attempts int `json:",omitempty"`
+	// Delay before the first retry, doubled for each one after, e.g. "2s"
+	BaseDelay string `json:",omitempty"`
+	// Upper bound of the delay, e.g. "1m"
+	MaxDelay string `json:",omitempty"`
+	// Randomizes delays by up to this fraction of them
+	Jitter float64 `json:",omitempty"`
+}
+
+type MaintenanceWindow struct {
+	// Days the window opens, e.g. ["Sat", "Sun"]. Every day when empty
+	Days []string `json:",omitempty"`
+	// "HH:MM". A window ending before it starts closes the next day.
+	Start string
+	End   string
+}
+
+type MaintenanceConfig struct {
+	// Name of the windows' time zone, e.g. "Europe/Berlin". The system's
+	// when empty
+	Timezone string `json:",omitempty"`
+	// When automatic updates may install. Anytime when empty.
+	Windows []MaintenanceWindow `json:",omitempty"`
+	// When automatic updates may download: "anytime", the default, or
+	// "window"
+	Download string `json:",omitempty"`
+}
+
+type RebootConfig struct {
+	// "immediate", "delayed", "window" or "never", the default
+	Policy string `json:",omitempty"`
+	// How long a delayed reboot waits, e.g. "10m"
+	Delay string `json:",omitempty"`
+	// Run before rebooting. A failure cancels the reboot.
+	PreRebootHook string `json:",omitempty"`
+	// Defaults to "systemctl reboot"
+	Command []string `json:",omitempty"`
 }
 
 type DeviceConfig struct {
@@ -41,17 +131,49 @@ type DeviceConfig struct {
 	BaseNotaryServerUrl        string
 	BaseNotaryCAFile           string
 	BaseCollectionName         string
+	BaseAllowedRoles           []string `json:",omitempty"`
 	PersonalityNotaryServerUrl string
 	PersonalityNotaryCAFile    string
 	PersonalityCollectionName  string
+	PersonalityAllowedRoles    []string            `json:",omitempty"`
+	Personalities              []PersonalityConfig `json:",omitempty"`
+	HistoryMaxSize             int64               `json:",omitempty"`
+	Retention                  RetentionConfig
+	Retry                      RetryConfig
+	Maintenance                MaintenanceConfig
+	Reboot                     RebootConfig
+
+	// Warn when TUF metadata expires within this duration, e.g. "168h"
+	MetadataExpiryWarning string `json:",omitempty"`
+	// Only targets tagged with this channel are updated to, any when empty
+	Channel string `json:",omitempty"`
+	// Identifies the device in rollouts. Generated when it's initialized.
+	DeviceId string `json:",omitempty"`
+	// PEM files presented to notary, tarball and OSTree servers requiring
+	// mutual TLS
+	TLSCertFile string `json:",omitempty"`
+	TLSKeyFile  string `json:",omitempty"`
+}
+
+type Personality struct {
+	configDir string
+	Config    PersonalityConfig
+	Notary    *NotaryClient
+	Runner    Runner
 }
 
 type Device struct {
-	configDir         string
-	Config            DeviceConfig
-	BaseNotary        *NotaryClient
-	PersonalityNotary *NotaryClient
+	configDir     string
+	Config        DeviceConfig
+	BaseNotary    *NotaryClient
+	Personalities []*Personality
 
 	HardwareId   string
 	OSTreeStatus *OSTreeStatus
+	// Runs ostree, docker and docker-compose for the device and its
+	// personalities
+	Runner Runner
+	// Where OSTree remote configs are written, DefaultOSTreeRemotesDir when
+	// empty
+	OSTreeRemotesDir string
 }
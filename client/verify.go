@@ -0,0 +1,244 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/cli/cli/compose/types"
+	"github.com/theupdateframework/notary/client"
+)
+
+const (
+	VerifyPass = "pass"
+	VerifyFail = "fail"
+	VerifySkip = "skip"
+)
+
+type VerifyCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+type VerifyReport struct {
+	Passed bool          `json:"passed"`
+	Checks []VerifyCheck `json:"checks"`
+}
+
+func (r *VerifyReport) add(name, status, details string, args ...interface{}) {
+	if len(args) > 0 {
+		details = fmt.Sprintf(details, args...)
+	}
+	if status == VerifyFail {
+		r.Passed = false
+	}
+	r.Checks = append(r.Checks, VerifyCheck{name, status, details})
+}
+
+func (r *VerifyReport) addErr(name string, err error) {
+	if err != nil {
+		r.add(name, VerifyFail, err.Error())
+	} else {
+		r.add(name, VerifyPass, "")
+	}
+}
+
+func inSignedTargets(targets []*client.TargetWithRole, target *client.TargetWithRole) bool {
+	hash := hex.EncodeToString(target.Hashes["sha256"])
+	for _, t := range targets {
+		if t.Name == target.Name && hex.EncodeToString(t.Hashes["sha256"]) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Device) verifyBase(report *VerifyReport, fsck bool) {
+	tgt, _, err := d.BaseTarget()
+	if err != nil {
+		report.add("base", VerifyFail, err.Error())
+		return
+	}
+	hash := hex.EncodeToString(tgt.Hashes["sha256"])
+	if hash != d.OSTreeStatus.Active {
+		report.add("base", VerifyFail, "active deployment %s != %s(%s)", d.OSTreeStatus.Active, tgt.Name, hash)
+	} else {
+		report.add("base", VerifyPass, "%s(%s) is active", tgt.Name, hash)
+	}
+
+	targets, err := d.BaseTargets()
+	if err != nil {
+		report.add("base signed", VerifyFail, err.Error())
+	} else if !inSignedTargets(targets, tgt) {
+		report.add("base signed", VerifyFail, "%s(%s) is not in the signed targets list", tgt.Name, hash)
+	} else {
+		report.add("base signed", VerifyPass, "")
+	}
+
+	if fsck {
+		_, err := runWith(context.Background(), d.Runner, "", "ostree", "fsck")
+		report.addErr("ostree fsck", err)
+	} else {
+		report.add("ostree fsck", VerifySkip, "")
+	}
+}
+
+// Compares the files extracted to the compose directory with the archive
+func compareComposeDir(archive, dir string, dcc DockerComposeCustom) error {
+	tr, err := openArchive(archive, dcc.ArchiveFormat)
+	if err != nil {
+		return err
+	}
+	var diffs []string
+	for true {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read archive: %s", err)
+		}
+		if dcc.TgzLeading {
+			idx := strings.Index(header.Name, "/")
+			if idx > 0 {
+				header.Name = header.Name[idx+1:]
+			}
+		}
+		file := path.Join(dir, header.Name)
+		switch header.Typeflag {
+		case tar.TypeReg:
+			expected := make([]byte, header.Size)
+			if _, err := io.ReadFull(tr, expected); err != nil {
+				return fmt.Errorf("Unable to read %s from archive: %s", header.Name, err)
+			}
+			found, err := ioutil.ReadFile(file)
+			if err != nil {
+				diffs = append(diffs, header.Name+" missing")
+			} else if !bytes.Equal(expected, found) {
+				diffs = append(diffs, header.Name+" modified")
+			}
+		case tar.TypeSymlink:
+			link, err := os.Readlink(file)
+			if err != nil {
+				diffs = append(diffs, header.Name+" missing")
+			} else if link != path.Join(path.Dir(file), header.Linkname) {
+				diffs = append(diffs, header.Name+" modified")
+			}
+		case tar.TypeDir:
+			if st, err := os.Stat(file); err != nil || !st.IsDir() {
+				diffs = append(diffs, header.Name+" missing")
+			}
+		}
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%s", strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+var composeProjectRe = regexp.MustCompile("[^-_a-z0-9]")
+
+// docker-compose derives the project name from the directory name
+func composeProjectName(dir string) string {
+	return composeProjectRe.ReplaceAllString(strings.ToLower(path.Base(dir)), "")
+}
+
+// Checks that the containers of each service run the image digest the
+// compose file pins
+func verifyContainers(r Runner, project *types.Config, composeDir string) error {
+	name := composeProjectName(composeDir)
+	var problems []string
+	for _, svc := range project.Services {
+		idx := strings.Index(svc.Image, "@")
+		if idx < 0 {
+			continue
+		}
+		digest := svc.Image[idx+1:]
+		out, err := runWith(context.Background(), r, "", "docker", "ps", "-q",
+			"--filter", "label=com.docker.compose.project="+name,
+			"--filter", "label=com.docker.compose.service="+svc.Name)
+		if err != nil {
+			return err
+		}
+		containers := strings.Fields(out)
+		if len(containers) == 0 {
+			problems = append(problems, svc.Name+" not running")
+			continue
+		}
+		for _, container := range containers {
+			out, err := runWith(context.Background(), r, "", "docker", "inspect", "--format", "{{.Image}}", container)
+			if err != nil {
+				return err
+			}
+			out, err = runWith(context.Background(), r, "", "docker", "image", "inspect", "--format", "{{range .RepoDigests}}{{.}} {{end}}", strings.TrimSpace(out))
+			if err != nil {
+				return err
+			}
+			found := false
+			for _, repoDigest := range strings.Fields(out) {
+				if strings.HasSuffix(repoDigest, "@"+digest) {
+					found = true
+				}
+			}
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s(%s) is not running %s", svc.Name, container, digest))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func (p *Personality) verify(report *VerifyReport) {
+	prefix := fmt.Sprintf("personality(%s)", p.Name())
+	tgt, dcc, err := p.Target()
+	if err != nil {
+		report.add(prefix, VerifyFail, err.Error())
+		return
+	}
+	hash := hex.EncodeToString(tgt.Hashes["sha256"])
+	report.add(prefix, VerifyPass, "%s(%s) is installed", tgt.Name, hash)
+
+	targets, err := p.Targets()
+	if err != nil {
+		report.add(prefix+" signed", VerifyFail, err.Error())
+	} else if !inSignedTargets(targets, tgt) {
+		report.add(prefix+" signed", VerifyFail, "%s(%s) is not in the signed targets list", tgt.Name, hash)
+	} else {
+		report.add(prefix+" signed", VerifyPass, "")
+	}
+
+	archive := cachedArchive(p.CacheDir(), hash)
+	project, err := loadComposeProject(archive, hash, *dcc)
+	report.addErr(prefix+" archive", err)
+	if err != nil {
+		return
+	}
+	report.addErr(prefix+" compose directory", compareComposeDir(archive, p.ComposeDir(), *dcc))
+	report.addErr(prefix+" containers", verifyContainers(p.Runner, project, p.ComposeDir()))
+}
+
+// Verify checks that the device is running exactly what its TUF targets
+// describe. fsck also checks the integrity of the OSTree repository which
+// can take a while.
+func (d *Device) Verify(fsck bool) *VerifyReport {
+	report := VerifyReport{Passed: true}
+	if d.BaseNotary != nil {
+		d.verifyBase(&report, fsck)
+	}
+	for _, p := range d.Personalities {
+		p.verify(&report)
+	}
+	return &report
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCompareComposeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf := createArchive(t, ArchiveTarGz, map[string]string{"foo/docker-compose.yml": "{}", "foo/env": "bar"})
+	archive := path.Join(dir, "archive.tgz")
+	if err := ioutil.WriteFile(archive, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	composeDir := path.Join(dir, "compose")
+	if err := os.Mkdir(composeDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dcc := DockerComposeCustom{TgzLeading: true}
+	if err := extractFile(archive, composeDir, "", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := compareComposeDir(archive, composeDir, dcc); err != nil {
+		t.Errorf("Compose directory should match: %s", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(composeDir, "env"), []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(composeDir, "docker-compose.yml")); err != nil {
+		t.Fatal(err)
+	}
+	err = compareComposeDir(archive, composeDir, dcc)
+	if err == nil {
+		t.Error("Compose directory should not match")
+	} else {
+		t.Logf("Error message: %s", err)
+	}
+}
+
+func TestComposeProjectName(t *testing.T) {
+	if name := composeProjectName("/var/tuftree/docker-compose-current"); name != "docker-compose-current" {
+		t.Errorf("Invalid project name: %s", name)
+	}
+	if name := composeProjectName("/var/tuftree/personalities/Vendor.Stack"); name != "vendorstack" {
+		t.Errorf("Invalid project name: %s", name)
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var versionOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+// Compares versions like "v38" or "1.2.3". Numeric components are compared
+// as numbers, anything else falls back to a string comparison.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		if i >= len(as) {
+			return -1
+		}
+		if i >= len(bs) {
+			return 1
+		}
+		ai, aErr := strconv.Atoi(as[i])
+		bi, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			if ai != bi {
+				if ai < bi {
+					return -1
+				}
+				return 1
+			}
+		} else if as[i] != bs[i] {
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return 0
+}
+
+func parseConstraint(term string) (string, string, error) {
+	term = strings.TrimSpace(term)
+	for _, op := range versionOps {
+		if strings.HasPrefix(term, op) {
+			ver := strings.TrimSpace(term[len(op):])
+			if len(ver) == 0 {
+				return "", "", fmt.Errorf("Missing version in constraint '%s'", term)
+			}
+			return op, ver, nil
+		}
+	}
+	if len(term) == 0 {
+		return "", "", fmt.Errorf("Empty version constraint")
+	}
+	return "=", term, nil
+}
+
+// Checks a version range like ">=v38, <v45" for syntax errors
+func validateVersionRange(versionRange string) error {
+	if len(strings.TrimSpace(versionRange)) == 0 {
+		return nil
+	}
+	for _, term := range strings.Split(versionRange, ",") {
+		if _, _, err := parseConstraint(term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true if version satisfies every comma separated constraint of
+// the range. An empty range matches everything.
+func versionInRange(version, versionRange string) (bool, error) {
+	if len(strings.TrimSpace(versionRange)) == 0 {
+		return true, nil
+	}
+	for _, term := range strings.Split(versionRange, ",") {
+		op, ver, err := parseConstraint(term)
+		if err != nil {
+			return false, err
+		}
+		cmp := compareVersions(version, ver)
+		var ok bool
+		switch op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
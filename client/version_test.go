@@ -0,0 +1,51 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"v38", "v38", 0},
+		{"v38", "v100", -1},
+		{"v100", "v38", 1},
+		{"1.2.3", "1.2", 1},
+		{"1.2", "1.2.3", -1},
+		{"v1.10", "v1.9", 1},
+	}
+	for _, test := range tests {
+		if cmp := compareVersions(test.a, test.b); cmp != test.cmp {
+			t.Errorf("compareVersions(%s, %s) %d != %d", test.a, test.b, cmp, test.cmp)
+		}
+	}
+}
+
+func TestVersionInRange(t *testing.T) {
+	tests := []struct {
+		version, versionRange string
+		ok                    bool
+	}{
+		{"v38", "", true},
+		{"v38", ">=v38", true},
+		{"v38", ">v38", false},
+		{"v40", ">=v38, <v45", true},
+		{"v45", ">=v38, <v45", false},
+		{"v38", "v38", true},
+		{"v38", "!=v38", false},
+	}
+	for _, test := range tests {
+		ok, err := versionInRange(test.version, test.versionRange)
+		if err != nil {
+			t.Errorf("versionInRange(%s, %s) failed: %s", test.version, test.versionRange, err)
+		} else if ok != test.ok {
+			t.Errorf("versionInRange(%s, %s) %v != %v", test.version, test.versionRange, ok, test.ok)
+		}
+	}
+
+	if err := validateVersionRange(">=v38, <"); err == nil {
+		t.Error("validateVersionRange should fail with a missing version")
+	}
+}
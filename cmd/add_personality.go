@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/tuftree/client"
+)
+
+var (
+	personalityConfig = client.PersonalityConfig{}
+	addPersonalityCmd = &cobra.Command{
+		Use:   "add-personality",
+		Short: "Configure an additional personality for the device",
+		Run:   doAddPersonality,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(addPersonalityCmd)
+	addLockFlags(addPersonalityCmd)
+
+	addPersonalityCmd.Flags().StringVarP(&personalityConfig.Name, "personality-name", "", "", "The name of the personality")
+	addPersonalityCmd.Flags().StringVarP(&personalityConfig.NotaryServerUrl, "personality-notary", "", "https://notary.foundries.io", "The notary server to use")
+	addPersonalityCmd.Flags().StringVarP(&personalityConfig.CollectionName, "personality-collection", "", "", "The notary collection providing DOCKER_COMPOSE details")
+	addPersonalityCmd.Flags().StringVarP(&personalityConfig.NotaryCAFile, "personality-notary-ca", "", "", "Use an additional CA for talking to the server")
+	addPersonalityCmd.Flags().StringSliceVarP(&personalityConfig.AllowedRoles, "personality-allowed-roles", "", nil, "Only accept targets signed by these roles, e.g. targets/releases")
+}
+
+func doAddPersonality(cmd *cobra.Command, args []string) {
+	p, err := device.AddPersonality(personalityConfig)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Personality(%s) added\n", p.Name())
+	fmt.Printf("Compose directory:\t%s\n", p.ComposeDir())
+}
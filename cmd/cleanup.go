@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanupKeep     int
+	cleanupNoOSTree bool
+	cleanupNoDocker bool
+	cleanupCmd      = &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove old personality archives, images and OSTree objects",
+		Run:   doCleanup,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(cleanupCmd)
+	addLockFlags(cleanupCmd)
+
+	cleanupCmd.Flags().IntVarP(&cleanupKeep, "keep", "", 0, "Personality archives to keep, including the current one. Defaults to the device's retention policy")
+	cleanupCmd.Flags().BoolVarP(&cleanupNoOSTree, "no-ostree", "", false, "Don't clean up OSTree deployments and objects")
+	cleanupCmd.Flags().BoolVarP(&cleanupNoDocker, "no-docker", "", false, "Don't remove docker images")
+}
+
+func doCleanup(cmd *cobra.Command, args []string) {
+	opts := device.CleanupOptions()
+	if cleanupKeep > 0 {
+		opts.KeepArchives = cleanupKeep
+	}
+	opts.SkipOSTree = cleanupNoOSTree
+	opts.SkipDocker = cleanupNoDocker
+	if err := device.Cleanup(opts); err != nil {
+		logrus.Fatal(err)
+	}
+}
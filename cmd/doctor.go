@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/tuftree/client"
+)
+
+var (
+	doctorJson bool
+	doctorCmd  = &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose common configuration and environment problems",
+		Run:   doDoctor,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().BoolVarP(&doctorJson, "json", "", false, "Print the findings as JSON")
+}
+
+func doDoctor(cmd *cobra.Command, args []string) {
+	findings := client.Diagnose(cmdConfigDir)
+
+	if doctorJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			logrus.Fatal(err)
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Printf("[%s]\t%s: %s\n", f.Severity, f.Check, f.Message)
+			if len(f.Advice) > 0 {
+				fmt.Printf("\t  -> %s\n", f.Advice)
+			}
+		}
+	}
+	for _, f := range findings {
+		if f.Severity == client.DoctorError {
+			os.Exit(1)
+		}
+	}
+}
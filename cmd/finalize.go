@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	finalizeCmd = &cobra.Command{
+		Use:   "finalize",
+		Short: "Confirm a base update after rebooting. Meant to be run at boot",
+		Run:   doFinalize,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(finalizeCmd)
+	addLockFlags(finalizeCmd)
+}
+
+func doFinalize(cmd *cobra.Command, args []string) {
+	if err := device.FinalizeBase(); err != nil {
+		logrus.Fatal(err)
+	}
+}
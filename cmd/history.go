@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/tuftree/client"
+)
+
+var (
+	historyJson   bool
+	historyKind   string
+	historyFailed bool
+	historyLimit  int
+	historyCmd    = &cobra.Command{
+		Use:   "history",
+		Short: "Display the update history of the device",
+		Run:   doHistory,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().BoolVarP(&historyJson, "json", "", false, "Print the history as JSON")
+	historyCmd.Flags().StringVarP(&historyKind, "kind", "", "", "Only show updates of this kind: base or personality")
+	historyCmd.Flags().StringVarP(&personalityName, "personality-name", "", "", "Only show updates of this personality")
+	historyCmd.Flags().BoolVarP(&historyFailed, "failed", "", false, "Only show failed updates")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "Only show the most recent N updates")
+}
+
+func doHistory(cmd *cobra.Command, args []string) {
+	entries, err := device.History().Entries()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	filtered := []client.HistoryEntry{}
+	for _, e := range entries {
+		if len(historyKind) > 0 && e.Kind != historyKind {
+			continue
+		}
+		if len(personalityName) > 0 && e.Personality != personalityName {
+			continue
+		}
+		if historyFailed && e.Result != client.HistoryFailure {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	if historyLimit > 0 && len(filtered) > historyLimit {
+		filtered = filtered[len(filtered)-historyLimit:]
+	}
+
+	if historyJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(filtered); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	for _, e := range filtered {
+		kind := e.Kind
+		if len(e.Personality) > 0 {
+			kind = fmt.Sprintf("%s(%s)", e.Kind, e.Personality)
+		}
+		from := e.FromTarget
+		if len(from) == 0 {
+			from = "-"
+		}
+		fmt.Printf("%s\t%s\t%s -> %s\t%s\t%dms\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), kind, from, e.ToTarget, e.Result, e.DurationMs, e.Trigger)
+		if len(e.Error) > 0 {
+			fmt.Printf("  Error: %s\n", e.Error)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/foundriesio/tuftree/client"
@@ -10,6 +11,7 @@ import (
 
 var (
 	deviceConfig  = client.DeviceConfig{}
+	genDeviceKey  bool
 	initializeCmd = &cobra.Command{
 		Use:   "initialize",
 		Short: "Set up initial configuration",
@@ -19,27 +21,54 @@ var (
 
 func init() {
 	RootCmd.AddCommand(initializeCmd)
+	addLockFlags(initializeCmd)
 
+	initializeCmd.Flags().StringVarP(&deviceConfig.HardwareId, "hardware-id", "", "", "The device's hardware ID, e.g. intel-corei7-64. Probed from the base notary server by default")
+	initializeCmd.Flags().StringVarP(&deviceConfig.Channel, "channel", "", "", "Only update to targets tagged with this channel, e.g. stable. By default every target is considered")
+	initializeCmd.Flags().StringVarP(&deviceConfig.DeviceId, "device-id", "", "", "Identifies the device in staged rollouts. Randomly generated by default")
+	initializeCmd.Flags().StringVarP(&deviceConfig.TLSCertFile, "tls-cert", "", "", "Client certificate presented to servers requiring mutual TLS")
+	initializeCmd.Flags().StringVarP(&deviceConfig.TLSKeyFile, "tls-key", "", "", "Private key of the client certificate")
+	initializeCmd.Flags().BoolVarP(&genDeviceKey, "gen-device-key", "", false, "Create a device key and a certificate signing request for it in the config dir")
 	initializeCmd.Flags().StringVarP(&deviceConfig.BaseNotaryServerUrl, "base-notary", "", "https://notary.foundries.io", "The notary server to use")
 	initializeCmd.Flags().StringVarP(&deviceConfig.BaseCollectionName, "base-notary-collection", "", "hub.foundries.io/lmp", "The notary collection providing OSTree images")
 	initializeCmd.Flags().StringVarP(&deviceConfig.BaseNotaryCAFile, "base-notary-ca", "", "", "Use an additional CA for talking to the server")
+	initializeCmd.Flags().StringSliceVarP(&deviceConfig.BaseAllowedRoles, "base-allowed-roles", "", nil, "Only accept base targets signed by these roles, e.g. targets/releases")
 
 	initializeCmd.Flags().StringVarP(&deviceConfig.PersonalityNotaryServerUrl, "personality-notary", "", "https://notary.foundries.io", "The notary server to use")
 	initializeCmd.Flags().StringVarP(&deviceConfig.PersonalityCollectionName, "personality-collection", "", "", "The notary collection providing DOCKER_COMPOSE details. If empty, no personality will be configured")
 	initializeCmd.Flags().StringVarP(&deviceConfig.PersonalityNotaryCAFile, "personality-notary-ca", "", "", "Use an additional CA for talking to the server")
+	initializeCmd.Flags().StringSliceVarP(&deviceConfig.PersonalityAllowedRoles, "personality-allowed-roles", "", nil, "Only accept personality targets signed by these roles")
 
 }
 
 func doInitialize(cmd *cobra.Command, args []string) {
+	if genDeviceKey && (len(deviceConfig.TLSCertFile) > 0 || len(deviceConfig.TLSKeyFile) > 0) {
+		logrus.Fatal("--gen-device-key can't be combined with --tls-cert or --tls-key")
+	}
+	// Before anything is fetched, so that the CSR can be signed for
+	// servers that refuse devices without a certificate
+	csr := ""
+	if genDeviceKey {
+		var err error
+		if csr, err = client.GenerateDeviceKey(cmdConfigDir, &deviceConfig); err != nil {
+			logrus.Fatal(err)
+		}
+	}
 	fmt.Println("Initializing device state ...")
 	d, err := client.DeviceInitialize(cmdConfigDir, deviceConfig)
 	if err != nil {
-		fmt.Println(err)
-		return
+		if len(csr) > 0 && len(deviceConfig.HardwareId) == 0 {
+			logrus.Fatalf("%s. Servers requiring mutual TLS can't be probed until the device CSR is signed, use --hardware-id", err)
+		}
+		logrus.Fatal(err)
 	}
 	fmt.Printf("Hardware-id:\t%s\n", d.HardwareId)
 	fmt.Printf("Active image:\t%s\n", d.OSTreeStatus.Active)
 	if d.OSTreeStatus.Pending != nil {
 		fmt.Printf("Pending image: %s\n", *d.OSTreeStatus.Pending)
 	}
+	if len(csr) > 0 {
+		fmt.Printf("Device CSR:\t%s\n", csr)
+		fmt.Printf("Save the signed certificate as %s\n", d.Config.TLSCertFile)
+	}
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -27,25 +28,40 @@ func doListBase(cmd *cobra.Command, args []string) {
 		fmt.Println("Device is not configured for base updates")
 		return
 	}
-	fmt.Println("Updates:")
-	targets, err := device.BaseTargets()
+	ctx, cancel := commandContext()
+	defer cancel()
+	list, err := device.BaseTargetList(ctx)
 	if err != nil {
+		fmt.Println("Updates:")
 		logrus.Error(err)
 		return
 	}
-	for _, target := range targets {
-		ver, hwid := client.BaseVersionSplit(target.Name)
+	fmt.Printf("Updates%s:\n", offlineNote(list))
+	revoked := client.NewRevocations(list.Targets)
+	for _, target := range list.Targets {
+		ver, hwid, err := client.BaseVersionSplit(target.Name)
+		if err != nil {
+			logrus.Debug(err)
+			continue
+		}
 		if hwid != device.HardwareId {
 			continue
 		}
 		hash := hex.EncodeToString(target.Hashes["sha256"])
 		fmt.Printf("%s\t%s\n", ver, hash)
+		fmt.Println("  Role:      ", target.Role)
+		if revoked.Revoked(target) {
+			fmt.Println("  Revoked:    true")
+		}
 		c, err := device.BaseNotary.OSTree(target.Custom)
 		if err != nil {
 			logrus.Error(err)
 		} else {
 			fmt.Println("  OSTreeURL: ", c.Url)
 			fmt.Println("  URL:       ", c.Uri)
+			if len(c.Tags) > 0 {
+				fmt.Println("  Tags:      ", strings.Join(c.Tags, ","))
+			}
 		}
 	}
 }
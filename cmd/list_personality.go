@@ -3,9 +3,12 @@ package cmd
 import (
 	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/tuftree/client"
 )
 
 var (
@@ -18,28 +21,51 @@ var (
 
 func init() {
 	RootCmd.AddCommand(listPersonalityCmd)
+
+	listPersonalityCmd.Flags().StringVarP(&personalityName, "personality-name", "", "", "Only list updates for this personality")
 }
 
 func doListPersonality(cmd *cobra.Command, args []string) {
-	if device.PersonalityNotary == nil {
-		fmt.Println("Device is not configured for personality updates")
-		return
-	}
-	fmt.Println("Updates:")
-	targets, err := device.PersonalityTargets()
+	personalities, err := selectedPersonalities()
 	if err != nil {
-		logrus.Error(err)
+		fmt.Println(err)
 		return
 	}
-	for _, target := range targets {
-		hash := hex.EncodeToString(target.Hashes["sha256"])
-		fmt.Printf("%s\t%s\n", target.Name, hash)
-		c, err := device.BaseNotary.DockerCompose(target.Custom)
+	ctx, cancel := commandContext()
+	defer cancel()
+	for _, p := range personalities {
+		list, err := p.TargetList(ctx)
 		if err != nil {
+			fmt.Printf("Updates(%s):\n", p.Name())
 			logrus.Error(err)
-		} else {
-			fmt.Println("  TgzURL: ", c.TgzUrl)
-			fmt.Println("  URL:    ", c.Uri)
+			continue
+		}
+		fmt.Printf("Updates(%s)%s:\n", p.Name(), offlineNote(list))
+		revoked := client.NewRevocations(list.Targets)
+		for _, target := range list.Targets {
+			if target.Name == client.BlocklistTarget {
+				continue
+			}
+			hash := hex.EncodeToString(target.Hashes["sha256"])
+			fmt.Printf("%s\t%s\n", target.Name, hash)
+			fmt.Println("  Role:   ", target.Role)
+			if revoked.Revoked(target) {
+				fmt.Println("  Revoked: true")
+			}
+			c, err := p.Notary.DockerCompose(target.Custom)
+			if err != nil {
+				logrus.Error(err)
+			} else {
+				if len(c.OCIArtifact) > 0 {
+					fmt.Println("  OCI:    ", c.OCIArtifact)
+				} else {
+					fmt.Println("  TgzURL: ", c.TgzUrl)
+				}
+				fmt.Println("  URL:    ", c.Uri)
+				if len(c.Tags) > 0 {
+					fmt.Println("  Tags:   ", strings.Join(c.Tags, ","))
+				}
+			}
 		}
 	}
 }
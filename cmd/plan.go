@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/tuftree/client"
+)
+
+var (
+	planJson bool
+	planCmd  = &cobra.Command{
+		Use:   "plan",
+		Short: "Show what an update would do without changing the device",
+		Run:   doPlan,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(planCmd)
+	addUpdateFlags(planCmd)
+
+	planCmd.Flags().BoolVarP(&planJson, "json", "", false, "Print the plan as JSON")
+}
+
+func doPlan(cmd *cobra.Command, args []string) {
+	ctx, cancel := commandContext()
+	defer cancel()
+	plan, err := planUpdate(ctx)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	report, err := device.DescribePlan(plan)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if planJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	if report.Base != nil {
+		printBaseReport(report.Base)
+	}
+	for _, p := range report.Personalities {
+		printPersonalityReport(p)
+	}
+	fmt.Printf("Download size:\t%d bytes\n", report.DiskRequired)
+	fmt.Printf("Disk available:\t%d bytes\n", report.DiskAvailable)
+}
+
+func printBaseReport(b *client.BaseReport) {
+	fmt.Println("Base:")
+	fmt.Printf("  Target:\t%s\n", b.Target)
+	fmt.Printf("  Role:\t\t%s\n", b.Role)
+	fmt.Printf("  Current hash:\t%s\n", b.CurrentHash)
+	fmt.Printf("  Desired hash:\t%s\n", b.DesiredHash)
+	if !b.Deploy {
+		fmt.Println("  OSTree:\talready active")
+	} else if b.Pull {
+		fmt.Printf("  OSTree:\tpull from %s and deploy\n", b.OSTreeUrl)
+	} else {
+		fmt.Println("  OSTree:\tdeploy from local repository")
+	}
+}
+
+func printPersonalityReport(p client.PersonalityReport) {
+	fmt.Printf("Personality(%s):\n", p.Name)
+	if len(p.CurrentTarget) > 0 {
+		fmt.Printf("  Target:\t%s (current %s)\n", p.Target, p.CurrentTarget)
+	} else {
+		fmt.Printf("  Target:\t%s\n", p.Target)
+	}
+	fmt.Printf("  Role:\t\t%s\n", p.Role)
+	fmt.Printf("  Current hash:\t%s\n", p.CurrentHash)
+	fmt.Printf("  Desired hash:\t%s\n", p.DesiredHash)
+	if p.Deferred {
+		fmt.Printf("  Deferred:\t%s\n", p.Reason)
+	}
+	if p.Cached {
+		fmt.Println("  Archive:\tcached")
+		for _, img := range p.Images {
+			if img.Signed {
+				fmt.Printf("  Image:\t\t%s (pull and validate)\n", img.Image)
+			} else {
+				fmt.Printf("  Image:\t\t%s\n", img.Image)
+			}
+		}
+		fmt.Printf("  Start:\t%s\n", strings.Join(p.Start, ", "))
+	} else {
+		fmt.Printf("  Archive:\tdownload from %s\n", p.Source)
+		fmt.Println("  Images:\tunknown until the archive is downloaded")
+	}
+	if len(p.Stop) > 0 {
+		fmt.Printf("  Stop:\t\t%s\n", strings.Join(p.Stop, ", "))
+	}
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rebootNow bool
+	rebootCmd = &cobra.Command{
+		Use:   "reboot",
+		Short: "Reboot into a deployed base update as allowed by the reboot policy",
+		Run:   doReboot,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(rebootCmd)
+	addLockFlags(rebootCmd)
+
+	rebootCmd.Flags().BoolVarP(&rebootNow, "now", "", false, "Reboot immediately, ignoring the reboot policy")
+}
+
+func doReboot(cmd *cobra.Command, args []string) {
+	if !device.RebootRequired() {
+		logrus.Info("No base update is waiting for a reboot")
+		return
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+	if err := device.RebootContext(ctx, time.Now(), rebootNow); err != nil {
+		logrus.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollbackBase        bool
+	rollbackPersonality bool
+	rollbackForce       bool
+	rollbackCmd         = &cobra.Command{
+		Use:   "rollback",
+		Short: "Return the base image and/or personality to the previously installed version",
+		Run:   doRollback,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+	addLockFlags(rollbackCmd)
+
+	rollbackCmd.Flags().BoolVarP(&rollbackBase, "base", "", false, "Switch to the previous OSTree deployment")
+	rollbackCmd.Flags().BoolVarP(&rollbackPersonality, "personality", "", false, "Reinstall the previous personality")
+	rollbackCmd.Flags().StringVarP(&personalityName, "personality-name", "", "", "Only roll back this personality. By default all personalities are rolled back")
+	rollbackCmd.Flags().BoolVarP(&rollbackForce, "force", "", false, "Roll back even if the previous version is no longer in the signed targets list")
+}
+
+func doRollback(cmd *cobra.Command, args []string) {
+	if !rollbackBase && !rollbackPersonality {
+		logrus.Fatal("Nothing to roll back, use --base and/or --personality")
+	}
+	if rollbackPersonality {
+		personalities, err := selectedPersonalities()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		for _, p := range personalities {
+			if err := device.RollbackPersonality(p, rollbackForce); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+	}
+	if rollbackBase {
+		if err := device.RollbackBase(rollbackForce); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Info("Reboot the device, e.g. with 'tuftree reboot --now', to run the previous base image")
+	}
+}
@@ -1,6 +1,14 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
@@ -8,20 +16,33 @@ import (
 )
 
 var (
-	cmdVerbose   bool
-	cmdConfigDir string
-	device       *client.Device
+	cmdVerbose      bool
+	cmdConfigDir    string
+	personalityName string
+	device          *client.Device
+	timeouts        = client.DefaultTimeouts
+
+	retryOverride client.RetryPolicy
+
+	lockWait   bool
+	lockNoWait bool
+	lockedCmds = make(map[*cobra.Command]bool)
+	lock       *client.Lock
 )
 
 var RootCmd = &cobra.Command{
-	Use:               "tuftree",
-	Short:             "tuftree keeps base OS images and personalities up-to-date",
-	PersistentPreRunE: initConfig,
+	Use:                "tuftree",
+	Short:              "tuftree keeps base OS images and personalities up-to-date",
+	PersistentPreRunE:  initConfig,
+	PersistentPostRunE: releaseLock,
 }
 
 func init() {
 	RootCmd.PersistentFlags().BoolVarP(&cmdVerbose, "verbose", "v", false, "Print more information")
 	RootCmd.PersistentFlags().StringVarP(&cmdConfigDir, "config-dir", "c", "/var/tuftree", "Configuration directory path to use")
+	RootCmd.PersistentFlags().IntVarP(&retryOverride.Attempts, "retries", "", 0, "Attempts per network operation. Defaults to the Retry section of config.json")
+	RootCmd.PersistentFlags().DurationVarP(&retryOverride.BaseDelay, "retry-delay", "", 0, "Delay before the first retry of a network operation")
+	RootCmd.PersistentFlags().DurationVarP(&retryOverride.MaxDelay, "retry-max-delay", "", 0, "Maximum delay between retries of a network operation")
 }
 
 func initConfig(cmd *cobra.Command, args []string) error {
@@ -31,7 +52,10 @@ func initConfig(cmd *cobra.Command, args []string) error {
 
 	logrus.Debugf("Configuration location: %s", cmdConfigDir)
 
-	if cmd == initializeCmd {
+	if lockedCmds[cmd] {
+		takeLock(cmd)
+	}
+	if cmd == initializeCmd || cmd == doctorCmd {
 		return nil
 	}
 	var err error
@@ -41,3 +65,87 @@ func initConfig(cmd *cobra.Command, args []string) error {
 	}
 	return nil
 }
+
+// Commands that change the device take the configuration directory's lock
+// so that, e.g. a cron job and an operator don't update at the same time
+func addLockFlags(cmd *cobra.Command) {
+	lockedCmds[cmd] = true
+	cmd.Flags().BoolVarP(&lockWait, "wait", "", true, "Wait for other tuftree processes using the configuration directory to finish")
+	cmd.Flags().BoolVarP(&lockNoWait, "no-wait", "", false, "Fail if another tuftree process is using the configuration directory")
+}
+
+func takeLock(cmd *cobra.Command) {
+	if cmd == initializeCmd {
+		if err := os.MkdirAll(cmdConfigDir, 0700); err != nil {
+			logrus.Fatalf("Unable to create config-dir: %s", err)
+		}
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+	var err error
+	lock, err = client.LockConfigDir(ctx, cmdConfigDir, strings.Join(os.Args, " "), lockWait && !lockNoWait)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	// logrus.Fatal exits without running PersistentPostRun
+	logrus.RegisterExitHandler(func() { releaseLock(cmd, nil) })
+}
+
+func releaseLock(cmd *cobra.Command, args []string) error {
+	if lock == nil {
+		return nil
+	}
+	err := lock.Unlock()
+	lock = nil
+	return err
+}
+
+// Returns a context that is cancelled on SIGINT or SIGTERM, bounds the
+// phases of an update by the timeout flags and retries network operations
+// as configured for the device and overridden by the retry flags
+func commandContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	policy := client.DefaultRetryPolicy
+	if device != nil {
+		var err error
+		if policy, err = device.RetryPolicy(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if retryOverride.Attempts > 0 {
+		policy.Attempts = retryOverride.Attempts
+	}
+	if retryOverride.BaseDelay > 0 {
+		policy.BaseDelay = retryOverride.BaseDelay
+	}
+	if retryOverride.MaxDelay > 0 {
+		policy.MaxDelay = retryOverride.MaxDelay
+	}
+	ctx = client.WithRetryPolicy(ctx, policy)
+	return client.WithTimeouts(ctx, timeouts), cancel
+}
+
+// Returns the personalities a command should operate on based on the
+// --personality-name flag
+func selectedPersonalities() ([]*client.Personality, error) {
+	if len(device.Personalities) == 0 {
+		return nil, fmt.Errorf("Device is not configured for personality updates")
+	}
+	if len(personalityName) == 0 {
+		return device.Personalities, nil
+	}
+	p, err := device.Personality(personalityName)
+	if err != nil {
+		return nil, err
+	}
+	return []*client.Personality{p}, nil
+}
+
+// Describes where a target list came from when it isn't fresh from the
+// notary server, e.g. " (offline, metadata cached 2021-03-04T10:00:00Z)"
+func offlineNote(list *client.TargetList) string {
+	if !list.Offline {
+		return ""
+	}
+	return fmt.Sprintf(" (offline, metadata cached %s)", list.CachedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	setChannelCmd = &cobra.Command{
+		Use:   "set-channel <channel>",
+		Short: "Only update to targets tagged with a channel. An empty channel considers every target",
+		Args:  cobra.ExactArgs(1),
+		Run:   doSetChannel,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(setChannelCmd)
+	addLockFlags(setChannelCmd)
+}
+
+func doSetChannel(cmd *cobra.Command, args []string) {
+	if err := device.SetChannel(args[0]); err != nil {
+		logrus.Fatal(err)
+	}
+	if len(args[0]) == 0 {
+		fmt.Println("Channel cleared, every target will be considered")
+	} else {
+		fmt.Printf("Channel set to %s\n", args[0])
+	}
+}
@@ -1,15 +1,22 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	tufclient "github.com/theupdateframework/notary/client"
 
 	"github.com/foundriesio/tuftree/client"
 )
 
 var (
-	statusCmd = &cobra.Command{
+	statusJson          bool
+	statusExpiryWarning time.Duration
+	statusCmd           = &cobra.Command{
 		Use:   "status",
 		Short: "Display status of device",
 		Run:   doStatus,
@@ -18,32 +25,203 @@ var (
 
 func init() {
 	RootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&personalityName, "personality-name", "", "", "Only display the status of this personality")
+	statusCmd.Flags().BoolVarP(&statusJson, "json", "", false, "Print the status as JSON")
+	statusCmd.Flags().DurationVarP(&statusExpiryWarning, "expiry-warning", "", 0, "Warn about TUF metadata expiring within this duration. Defaults to MetadataExpiryWarning from config.json or 168h")
+}
+
+type personalityStatus struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Revoked bool   `json:"revoked,omitempty"`
+	Latest  string `json:"latest,omitempty"`
+	Offline bool   `json:"offline,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	latestNote string
+}
+
+type deviceStatus struct {
+	HardwareId    string              `json:"hardwareId"`
+	DeviceId      string              `json:"deviceId"`
+	Channel       string              `json:"channel,omitempty"`
+	ActiveImage   string              `json:"activeImage"`
+	PendingImage  string              `json:"pendingImage,omitempty"`
+	PendingBase   string              `json:"pendingBase,omitempty"`
+	BaseVersion   string              `json:"baseVersion,omitempty"`
+	BaseRevoked   bool                `json:"baseRevoked,omitempty"`
+	BaseLatest    string              `json:"baseLatest,omitempty"`
+	BaseOffline   bool                `json:"baseOffline,omitempty"`
+	BaseError     string              `json:"baseError,omitempty"`
+	Personalities []personalityStatus `json:"personalities,omitempty"`
+	Metadata      []client.RoleExpiry `json:"metadata"`
+
+	baseLatestNote string
 }
 
 func doStatus(cmd *cobra.Command, args []string) {
-	fmt.Printf("Hardware-id:\t%s\n", device.HardwareId)
-	fmt.Printf("Active image:\t%s\n", device.OSTreeStatus.Active)
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	status := deviceStatus{
+		HardwareId:  device.HardwareId,
+		DeviceId:    device.Config.DeviceId,
+		Channel:     device.Config.Channel,
+		ActiveImage: device.OSTreeStatus.Active,
+	}
 	if device.OSTreeStatus.Pending != nil {
-		fmt.Printf("Pending image:\t%s\n", *device.OSTreeStatus.Pending)
+		status.PendingImage = *device.OSTreeStatus.Pending
+	}
+	if pending, err := device.PendingBaseTarget(); err != nil {
+		logrus.Error(err)
+	} else if pending != nil {
+		status.PendingBase = pending.Name
 	}
-
 	if device.BaseNotary != nil {
 		tgt, _, err := device.BaseTarget()
+		if err == nil {
+			status.BaseVersion, _, err = client.BaseVersionSplit(tgt.Name)
+		}
+		if err != nil {
+			status.BaseError = fmt.Sprintf("Unable to find base version information: %s", err)
+		}
+		list, err := device.BaseTargetList(ctx)
 		if err != nil {
-			fmt.Printf("Unable to find base version information: %s\n", err)
+			logrus.Errorf("Unable to list base updates: %s", err)
 		} else {
-			ver, _ := client.BaseVersionSplit(tgt.Name)
-			fmt.Printf("Base Version:\t%s\n", ver)
+			status.BaseRevoked = tgt != nil && client.NewRevocations(list.Targets).Revoked(tgt)
+			if latest := latestBase(list.Targets); latest != nil {
+				status.BaseLatest = latest.Name
+				status.BaseOffline = list.Offline
+				status.baseLatestNote = offlineNote(list)
+			}
 		}
 	}
 
-	if device.PersonalityNotary != nil {
-		tgt, _, err := device.PersonalityTarget()
+	if len(device.Personalities) > 0 {
+		personalities, err := selectedPersonalities()
 		if err != nil {
-			fmt.Printf("Unable to find personality version information: %s\n", err)
+			fmt.Println(err)
+			return
+		}
+		for _, p := range personalities {
+			ps := personalityStatus{Name: p.Name()}
+			tgt, _, err := p.Target()
+			if err != nil {
+				ps.Error = fmt.Sprintf("Unable to find personality(%s) version information: %s", p.Name(), err)
+			} else {
+				ps.Version = tgt.Name
+			}
+			list, err := p.TargetList(ctx)
+			if err != nil {
+				logrus.Errorf("Unable to list personality(%s) updates: %s", p.Name(), err)
+			} else {
+				ps.Revoked = tgt != nil && client.NewRevocations(list.Targets).Revoked(tgt)
+				if available := device.AvailableTargets(list.Targets); len(available) > 0 {
+					ps.Latest = available[0].Name
+					ps.Offline = list.Offline
+					ps.latestNote = offlineNote(list)
+				}
+			}
+			status.Personalities = append(status.Personalities, ps)
+		}
+	}
+
+	window := statusExpiryWarning
+	if window == 0 {
+		var err error
+		if window, err = device.ExpiryWarning(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	expiries, err := device.MetadataExpiry(window)
+	if err != nil {
+		logrus.Errorf("Unable to read TUF metadata expiry: %s", err)
+	}
+	status.Metadata = expiries
+	warnMetadataExpiry(expiries)
+
+	if statusJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(status); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("Hardware-id:\t%s\n", status.HardwareId)
+	fmt.Printf("Device-id:\t%s\n", status.DeviceId)
+	if len(status.Channel) > 0 {
+		fmt.Printf("Channel:\t%s\n", status.Channel)
+	}
+	fmt.Printf("Active image:\t%s\n", status.ActiveImage)
+	if len(status.PendingImage) > 0 {
+		fmt.Printf("Pending image:\t%s\n", status.PendingImage)
+	}
+	if len(status.PendingBase) > 0 {
+		fmt.Printf("Pending base:\t%s (reboot required)\n", status.PendingBase)
+	}
+	if len(status.BaseError) > 0 {
+		fmt.Println(status.BaseError)
+	} else if len(status.BaseVersion) > 0 {
+		fmt.Printf("Base Version:\t%s%s\n", status.BaseVersion, revokedNote(status.BaseRevoked))
+	}
+	if len(status.BaseLatest) > 0 {
+		ver, _, _ := client.BaseVersionSplit(status.BaseLatest)
+		fmt.Printf("Base Latest:\t%s%s\n", ver, status.baseLatestNote)
+	}
+	for _, ps := range status.Personalities {
+		if len(ps.Error) > 0 {
+			fmt.Println(ps.Error)
 		} else {
-			fmt.Printf("Personality Version:\t%s\n", tgt.Name)
+			fmt.Printf("Personality(%s) Version:\t%s%s\n", ps.Name, ps.Version, revokedNote(ps.Revoked))
+		}
+		if len(ps.Latest) > 0 {
+			fmt.Printf("Personality(%s) Latest:\t%s%s\n", ps.Name, ps.Latest, ps.latestNote)
+		}
+	}
+	if len(status.Metadata) > 0 {
+		fmt.Println("TUF metadata expiry:")
+		for _, r := range status.Metadata {
+			marker := ""
+			if r.Expired {
+				marker = "\tEXPIRED"
+			} else if r.Expiring {
+				marker = "\tEXPIRING"
+			}
+			fmt.Printf("  %s %s\t%s%s\n", r.Collection, r.Role, r.Expires.Format(time.RFC3339), marker)
 		}
 	}
+}
+
+// Returns the newest base target available for the device's hardware, nil
+// when there is none
+func latestBase(targets []*tufclient.TargetWithRole) *tufclient.TargetWithRole {
+	for _, target := range device.AvailableTargets(targets) {
+		_, hwid, err := client.BaseVersionSplit(target.Name)
+		if err == nil && hwid == device.HardwareId {
+			return target
+		}
+	}
+	return nil
+}
 
+func revokedNote(revoked bool) string {
+	if revoked {
+		return " (REVOKED, run 'tuftree update --replace-revoked')"
+	}
+	return ""
+}
+
+// Logs a warning for each role whose metadata has expired or will soon
+func warnMetadataExpiry(expiries []client.RoleExpiry) {
+	for _, r := range expiries {
+		if r.Expired {
+			logrus.Warnf("TUF metadata has expired: %s", r)
+		} else if r.Expiring {
+			logrus.Warnf("TUF metadata expires in %s: %s", time.Until(r.Expires).Round(time.Minute), r)
+		}
+	}
 }
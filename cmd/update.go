@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	tufclient "github.com/theupdateframework/notary/client"
@@ -11,6 +15,9 @@ import (
 var (
 	baseVer        string
 	personalityVer string
+	updateTrigger  string
+	replaceRevoked bool
+	updateAuto     bool
 	updateCmd      = &cobra.Command{
 		Use:   "update",
 		Short: "Update the base image and/or personality of the device",
@@ -20,62 +27,128 @@ var (
 
 func init() {
 	RootCmd.AddCommand(updateCmd)
+	addLockFlags(updateCmd)
+	addUpdateFlags(updateCmd)
 
-	updateCmd.Flags().StringVarP(&baseVer, "base", "", "latest", "The version to update to. If set empty, no update will be performed")
-	updateCmd.Flags().StringVarP(&personalityVer, "personality", "", "latest", "The version to update to. If set empty, no update will be performed")
+	updateCmd.Flags().StringVarP(&updateTrigger, "trigger", "", "manual", "What triggered this update, recorded in the update history")
+	updateCmd.Flags().BoolVarP(&updateAuto, "auto", "", false, "Run as an automatic update, only installing within the maintenance windows unless a target is urgent")
+	updateCmd.Flags().DurationVarP(&timeouts.Download, "download-timeout", "", client.DefaultTimeouts.Download, "Maximum time to download a personality archive, 0 for no limit")
+	updateCmd.Flags().DurationVarP(&timeouts.Pull, "pull-timeout", "", client.DefaultTimeouts.Pull, "Maximum time for an ostree pull or docker pull, 0 for no limit")
+	updateCmd.Flags().DurationVarP(&timeouts.Compose, "compose-timeout", "", client.DefaultTimeouts.Compose, "Maximum time to stop or start a personality's containers, 0 for no limit")
 }
 
-func doUpdate(cmd *cobra.Command, args []string) {
-	var base, personality *tufclient.TargetWithRole
+// Flags shared by the commands that select updates
+func addUpdateFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&baseVer, "base", "", "latest", "The version to update to. If set empty, no update will be performed")
+	cmd.Flags().StringVarP(&personalityVer, "personality", "", "latest", "The version to update to. If set empty, no update will be performed")
+	cmd.Flags().StringVarP(&personalityName, "personality-name", "", "", "Only update this personality. By default all personalities are updated")
+	cmd.Flags().BoolVarP(&replaceRevoked, "replace-revoked", "", false, "Only update the base and personalities running a revoked target")
+	cmd.Flags().DurationVarP(&timeouts.Metadata, "metadata-timeout", "", client.DefaultTimeouts.Metadata, "Maximum time to fetch TUF metadata from a notary server, 0 for no limit")
+}
+
+// Finds the base target requested by the update flags. nil is returned when
+// only revoked targets are to be replaced and the current one isn't.
+func selectBase(ctx context.Context) (*tufclient.TargetWithRole, error) {
+	logrus.Info("Probing server for base updates")
+	targets, err := device.BaseTargetsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if replaceRevoked {
+		if cur, _, err := device.BaseTarget(); err == nil && !client.NewRevocations(targets).Revoked(cur) {
+			logrus.Infof("Base %s has not been revoked", cur.Name)
+			return nil, nil
+		}
+	}
+	target, err := device.FindBaseTarget(targets, baseVer)
+	if err != nil {
+		return nil, err
+	} else if target == nil {
+		return nil, fmt.Errorf("Can't find base update")
+	}
+	return target, nil
+}
+
+// Finds the targets requested by the update flags and orders them
+func planUpdate(ctx context.Context) (*client.UpdatePlan, error) {
+	var base *tufclient.TargetWithRole
+	var selected, personalities []*client.Personality
+	var personalityTargets []*tufclient.TargetWithRole
 
 	if device.BaseNotary == nil && len(baseVer) > 0 {
 		logrus.Error("Device is not configured for base updates")
 	} else if len(baseVer) > 0 {
-		logrus.Info("Probing server for base updates")
-		targets, err := device.BaseTargets()
+		var err error
+		if base, err = selectBase(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(personalityVer) > 0 {
+		var err error
+		selected, err = selectedPersonalities()
 		if err != nil {
 			logrus.Error(err)
-			return
-		}
-		for _, target := range targets {
-			ver, _ := client.BaseVersionSplit(target.Name)
-			if baseVer == "latest" || ver == baseVer {
-				base = target
-				break
-			}
-		}
-		if base == nil {
-			logrus.Fatal("Can't find base update")
 		}
 	}
-	if device.PersonalityNotary == nil && len(personalityVer) > 0 {
-		logrus.Error("Device is not configured for personality updates")
-	} else if len(personalityVer) > 0 {
-		logrus.Info("Probing server for personality updates")
-		targets, err := device.PersonalityTargets()
+	for _, p := range selected {
+		logrus.Infof("Probing server for personality(%s) updates", p.Name())
+		targets, err := p.TargetsContext(ctx)
 		if err != nil {
-			logrus.Error(err)
-			return
+			return nil, err
 		}
-		for _, target := range targets {
-			if personalityVer == "latest" || target.Name == personalityVer {
-				personality = target
-				break
+		if replaceRevoked {
+			if cur, _, err := p.Target(); err == nil && !client.NewRevocations(targets).Revoked(cur) {
+				logrus.Infof("Personality(%s) %s has not been revoked", p.Name(), cur.Name)
+				continue
 			}
 		}
-		if personality == nil {
-			logrus.Fatal("Can't find personality update")
+		personality, err := device.FindPersonalityTarget(targets, personalityVer)
+		if err != nil {
+			return nil, err
+		} else if personality == nil {
+			return nil, fmt.Errorf("Can't find personality(%s) update", p.Name())
 		}
+		personalities = append(personalities, p)
+		personalityTargets = append(personalityTargets, personality)
 	}
 
-	if base != nil {
-		if err := device.UpdateBase(base); err != nil {
-			logrus.Fatal(err)
-		}
+	return device.PlanUpdate(base, personalities, personalityTargets)
+}
+
+func doUpdate(cmd *cobra.Command, args []string) {
+	ctx, cancel := commandContext()
+	defer cancel()
+	if err := device.EnsureDeviceId(); err != nil {
+		logrus.Fatal(err)
 	}
-	if personality != nil {
-		if err := device.UpdatePersonality(personality); err != nil {
-			logrus.Fatal(err)
-		}
+	// A base deployed by an earlier run may be running now
+	if err := device.FinalizeBase(); err != nil {
+		logrus.Error(err)
+	}
+	plan, err := planUpdate(ctx)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	plan.Trigger = updateTrigger
+	if updateAuto && !cmd.Flags().Changed("trigger") {
+		plan.Trigger = "auto"
+	}
+	if window, err := device.ExpiryWarning(); err != nil {
+		logrus.Warn(err)
+	} else if expiries, err := device.MetadataExpiry(window); err != nil {
+		logrus.Warnf("Unable to read TUF metadata expiry: %s", err)
+	} else {
+		warnMetadataExpiry(expiries)
+	}
+	if updateAuto {
+		err = device.ApplyPlanAutomaticContext(ctx, plan, time.Now())
+	} else {
+		err = device.ApplyPlanContext(ctx, plan)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := device.RebootContext(ctx, time.Now(), false); err != nil {
+		logrus.Fatal(err)
 	}
 }
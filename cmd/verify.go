@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyFsck bool
+	verifyJson bool
+	verifyCmd  = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the device is running what its TUF targets describe",
+		Run:   doVerify,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().BoolVarP(&verifyFsck, "fsck", "", false, "Also check the integrity of the OSTree repository")
+	verifyCmd.Flags().BoolVarP(&verifyJson, "json", "", false, "Print the report as JSON")
+}
+
+func doVerify(cmd *cobra.Command, args []string) {
+	report := device.Verify(verifyFsck)
+
+	if verifyJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			logrus.Fatal(err)
+		}
+	} else {
+		for _, check := range report.Checks {
+			fmt.Printf("%s\t%s", check.Status, check.Name)
+			if len(check.Details) > 0 {
+				fmt.Printf(": %s", check.Details)
+			}
+			fmt.Println()
+		}
+	}
+	if !report.Passed {
+		os.Exit(1)
+	}
+}